@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	status "github.com/Financial-Times/service-status-go/httphandlers"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var adminAddress string
+
+// newAdminHandler serves the operational endpoints of the log-collector
+func newAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/__health", healthHandler())
+	mux.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(gtgCheck))
+	mux.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
+	return mux
+}
+
+// startAdminServer serves the operational endpoints in the background. A failure doesn't stop the log collection.
+func startAdminServer() {
+	if adminAddress == "" {
+		return
+	}
+	go func() {
+		log.Printf("Serving the admin endpoints on %v\n", adminAddress)
+		if err := http.ListenAndServe(adminAddress, newAdminHandler()); err != nil {
+			log.Printf("Admin server stopped: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AdminMetrics(t *testing.T) {
+	server := httptest.NewServer(newAdminHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "log_collector_lines_read_total")
+	assert.Contains(t, string(body), "log_collector_batches_built_total")
+}
+
+func Test_AdminHealthEndpoints(t *testing.T) {
+	server := httptest.NewServer(newAdminHandler())
+	defer server.Close()
+
+	for _, path := range []string{"/__health", "/__gtg", "/__build-info"} {
+		resp, err := http.Get(server.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+}
@@ -2,6 +2,8 @@ package filter
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -11,6 +13,8 @@ import (
 // cacheTime time to cache the cluster status
 const cacheTime = 30
 
+var lookupHost = net.LookupHost
+
 type cluster struct {
 	dnsAddress string
 	tag        string
@@ -52,3 +56,15 @@ func (mc monitoredClusterService) isActive() (bool, error) {
 	mc.cachedStatus = false
 	return false, errors.New("address could not be resolved, maybe it is invalid")
 }
+
+// CheckClusterDNS fails if the DNS address of the cluster can't be resolved, which the monitoring events depend on
+func CheckClusterDNS() (string, error) {
+	if DNSAddress == "" {
+		return "No cluster DNS address configured", nil
+	}
+	addrs, err := lookupHost(DNSAddress)
+	if err != nil {
+		return "", fmt.Errorf("cluster DNS address %v could not be resolved: %v", DNSAddress, err)
+	}
+	return fmt.Sprintf("%v resolves to %v", DNSAddress, strings.Join(addrs, ", ")), nil
+}
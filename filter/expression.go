@@ -0,0 +1,447 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// predicate is a compiled expression, evaluated against the properties of a log message
+type predicate func(m map[string]interface{}) bool
+
+// operand looks up a field of an expression
+type operand func(m map[string]interface{}) (interface{}, bool)
+
+// compileExpression compiles the expression language of the rules, e.g.
+//
+//	SERVICE_NAME == "x" && level in ["debug", "trace"]
+//	status >= 500 || MESSAGE =~ "(?i)timeout"
+//	!(monitoring_event == "true")
+//
+// Fields are compared with string, number, boolean and null literals using == != < <= > >=, matched against regular
+// expressions with =~ and !~, and looked up in lists with in. A field on its own is true if it is present, not empty,
+// not false and not 0. Conditions are combined with &&, || and !, and grouped with parentheses. A dot in a field name
+// looks up the nested properties. Missing fields only equal null.
+func compileExpression(expression string) (predicate, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %v at column %v", t, t.pos)
+	}
+	return pred, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenField
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{} //of the literals
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of expression"
+	}
+	return "`" + t.text + "`"
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for ; end < len(s) && s[end] != s[i]; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at column %v", i+1)
+			}
+			quoted := s[i : end+1]
+			if c == '\'' {
+				quoted = `"` + strings.Replace(strings.Replace(s[i+1:end], `\'`, `'`, -1), `"`, `\"`, -1) + `"`
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %v at column %v", s[i:end+1], i+1)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s[i : end+1], value: value, pos: i + 1})
+			i = end + 1
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '.' || s[end] == 'e' || s[end] == 'E' || unicode.IsDigit(rune(s[end]))) {
+				end++
+			}
+			value, err := strconv.ParseFloat(s[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %v at column %v", s[i:end], i+1)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[i:end], value: value, pos: i + 1})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(s) && isFieldChar(rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenField, text: s[i:end], pos: i + 1})
+			i = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i + 1})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at column %v", c, i+1)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, pos: len(s) + 1}), nil
+}
+
+func isFieldChar(c rune) bool {
+	return c == '_' || c == '.' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// parser is a recursive descent parser of the expressions, building the predicates as it goes
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) takeOperator(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.takeOperator("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(m map[string]interface{}) bool { return l(m) || right(m) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (predicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.takeOperator("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(m map[string]interface{}) bool { return l(m) && right(m) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (predicate, error) {
+	if p.takeOperator("!") {
+		pred, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(m map[string]interface{}) bool { return !pred(m) }, nil
+	}
+	if p.takeOperator("(") {
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.takeOperator(")") {
+			t := p.peek()
+			return nil, fmt.Errorf("expected `)` but found %v at column %v", t, t.pos)
+		}
+		return pred, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (predicate, error) {
+	t := p.take()
+	if t.kind != tokenField {
+		return nil, fmt.Errorf("expected a field but found %v at column %v", t, t.pos)
+	}
+	field := lookup(t.text)
+
+	op := p.peek()
+	switch {
+	case op.kind == tokenField && op.text == "in":
+		p.take()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return func(m map[string]interface{}) bool {
+			v, found := field(m)
+			for _, value := range values {
+				if found && equal(v, value) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case op.kind == tokenOperator && (op.text == "=~" || op.text == "!~"):
+		p.take()
+		pattern := p.take()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("expected a regular expression string after %v but found %v at column %v", op, pattern, pattern.pos)
+		}
+		re, err := regexp.Compile(pattern.value.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at column %v: %v", pattern.pos, err)
+		}
+		negated := op.text == "!~"
+		return func(m map[string]interface{}) bool {
+			v, found := field(m)
+			return found && re.MatchString(toString(v)) != negated
+		}, nil
+	case op.kind == tokenOperator && isComparison(op.text):
+		p.take()
+		literal, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return comparison(field, op.text, literal), nil
+	}
+	return func(m map[string]interface{}) bool {
+		v, found := field(m)
+		return found && truthy(v)
+	}, nil
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseList() ([]interface{}, error) {
+	if !p.takeOperator("[") {
+		t := p.peek()
+		return nil, fmt.Errorf("expected a list after `in` but found %v at column %v", t, t.pos)
+	}
+	var values []interface{}
+	for !p.takeOperator("]") {
+		if len(values) > 0 && !p.takeOperator(",") {
+			t := p.peek()
+			return nil, fmt.Errorf("expected `,` or `]` but found %v at column %v", t, t.pos)
+		}
+		v, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.take()
+	switch {
+	case t.kind == tokenString || t.kind == tokenNumber:
+		return t.value, nil
+	case t.kind == tokenField && t.text == "true":
+		return true, nil
+	case t.kind == tokenField && t.text == "false":
+		return false, nil
+	case t.kind == tokenField && t.text == "null":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("expected a string, number, true, false or null but found %v at column %v", t, t.pos)
+}
+
+// lookup returns the property of the log message, following the dots into the nested properties
+func lookup(name string) operand {
+	path := strings.Split(name, ".")
+	return func(m map[string]interface{}) (interface{}, bool) {
+		if v, found := m[name]; found {
+			return v, true
+		}
+		var current interface{} = m
+		for _, key := range path {
+			nested, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = nested[key]; !ok {
+				return nil, false
+			}
+		}
+		return current, true
+	}
+}
+
+func comparison(field operand, op string, literal interface{}) predicate {
+	switch op {
+	case "==":
+		return func(m map[string]interface{}) bool {
+			v, found := field(m)
+			if !found {
+				return literal == nil
+			}
+			return equal(v, literal)
+		}
+	case "!=":
+		return func(m map[string]interface{}) bool {
+			v, found := field(m)
+			if !found {
+				return literal != nil
+			}
+			return !equal(v, literal)
+		}
+	}
+	// the orderings compare numbers, or strings when the literal is a string
+	return func(m map[string]interface{}) bool {
+		v, found := field(m)
+		if !found {
+			return false
+		}
+		var c int
+		if s, ok := literal.(string); ok {
+			c = strings.Compare(toString(v), s)
+		} else {
+			n, ok := toNumber(v)
+			l, isNumber := literal.(float64)
+			if !ok || !isNumber {
+				return false
+			}
+			c = compareNumbers(n, l)
+		}
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}
+}
+
+// equal compares the property with the literal, converting the property to the type of the literal
+func equal(v interface{}, literal interface{}) bool {
+	switch l := literal.(type) {
+	case nil:
+		return v == nil
+	case string:
+		return v != nil && toString(v) == l
+	case float64:
+		n, ok := toNumber(v)
+		return ok && n == l
+	case bool:
+		b, ok := v.(bool)
+		if !ok {
+			b, ok = v == "true", v == "true" || v == "false"
+		}
+		return ok && b == l
+	}
+	return false
+}
+
+func compareNumbers(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != "" && t != "false"
+	case float64:
+		return t != 0
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressions(t *testing.T) {
+	m := map[string]interface{}{
+		"SERVICE_NAME":     "content-public-read",
+		"level":            "debug",
+		"status":           float64(503),
+		"time-ms":          "1200",
+		"monitoring_event": "true",
+		"empty":            "",
+		"MESSAGE":          "Request timed out",
+		"request":          map[string]interface{}{"method": "GET"},
+	}
+	expressions := map[string]bool{
+		`SERVICE_NAME == "content-public-read" && level in ["debug", "trace"]`: true,
+		`SERVICE_NAME == 'content-public-read' && level in ["info"]`:           false,
+		`level in []`:                          false,
+		`status >= 500`:                        true,
+		`status < 500 || time-ms > 1000`:       true,
+		`status == 503 && status != 200`:       true,
+		`time-ms <= 1e3`:                       false,
+		`MESSAGE =~ "(?i)TIMED OUT"`:           true,
+		`MESSAGE !~ "timed"`:                   false,
+		`monitoring_event && !empty`:           true,
+		`monitoring_event == true`:             true,
+		`missing == null && missing != "x"`:    true,
+		`missing`:                              false,
+		`missing > 0 || missing == 0`:          false,
+		`!(status >= 500 && level == "debug")`: false,
+		`request.method == "GET"`:              true,
+		`level > "a" && level < "e"`:           true,
+		`status == "503"`:                      true,
+		`SERVICE_NAME == "a" || (level == "debug" && status > 400)`: true,
+	}
+	for expression, expected := range expressions {
+		matches, err := compileExpression(expression)
+		if assert.NoError(t, err, expression) {
+			assert.Equal(t, expected, matches(m), expression)
+		}
+	}
+}
+
+func TestInvalidExpressions(t *testing.T) {
+	invalid := map[string]string{
+		``:                     "expected a field but found end of expression at column 1",
+		`level ==`:             "found end of expression at column 9",
+		`level == debug`:       "expected a string, number, true, false or null but found `debug` at column 10",
+		`(level == "debug"`:    "expected `)` but found end of expression",
+		`level == "debug")`:    "unexpected `)` at column 17",
+		`level in "debug"`:     "expected a list after `in`",
+		`level in ["a" "b"]`:   "expected `,` or `]`",
+		`MESSAGE =~ "("`:       "invalid regular expression at column 12",
+		`MESSAGE =~ level`:     "expected a regular expression string",
+		`level == "debug`:      "unterminated string at column 10",
+		`level = "debug"`:      "unexpected '=' at column 7",
+		`status > 1.2.3`:       "invalid number 1.2.3 at column 10",
+		`level == "a" && && a`: "expected a field but found `&&` at column 17",
+	}
+	for expression, expected := range invalid {
+		_, err := compileExpression(expression)
+		if assert.Error(t, err, expression) {
+			assert.Contains(t, err.Error(), expected, expression)
+		}
+	}
+}
+
+func TestExpressionRules(t *testing.T) {
+	defer UseRules(DefaultRules())
+	r, err := ParseRules([]byte(`
+expressions:
+  - when: 'SERVICE_NAME == "audit"'
+    action: keep
+  - when: 'level in ["debug", "trace"]'
+    action: drop
+  - when: 'status >= 500'
+    action: tag
+    tags:
+      alert: "true"
+`))
+	assert.NoError(t, err)
+	UseRules(r)
+
+	debug := map[string]interface{}{"CONTAINER_NAME": "k8s_content_content-pod_default", "MESSAGE": `{"level":"debug","msg":"noise"}`}
+	assert.False(t, processMessage(debug), "the extracted fields should be matched")
+
+	audit := map[string]interface{}{"CONTAINER_NAME": "k8s_audit_audit-pod_default", "MESSAGE": `{"level":"debug","msg":"kept"}`}
+	assert.True(t, processMessage(audit), "the first matching rule should decide")
+
+	failed := map[string]interface{}{"MESSAGE": `{"level":"error","status":503}`}
+	assert.True(t, processMessage(failed))
+	assert.Equal(t, "true", failed["alert"])
+}
+
+func TestInvalidExpressionRules(t *testing.T) {
+	invalid := map[string]string{
+		"expressions:\n  - when: 'level =='\n    action: drop\n":                "expressions[0]: invalid when expression: expected a string",
+		"expressions:\n  - when: 'level'\n    action: ignore\n":                 `expressions[0]: unknown action "ignore"`,
+		"expressions:\n  - action: drop\n":                                      "expressions[0]: the when expression is required",
+		"expressions:\n  - when: 'level'\n    action: tag\n":                    "expressions[0]: the tag action requires tags",
+		"expressions:\n  - when: 'level'\n    action: drop\n    tags: {a: b}\n": "expressions[0]: only the tag action has tags",
+	}
+	for rules, expected := range invalid {
+		_, err := ParseRules([]byte(rules))
+		if assert.Error(t, err, rules) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
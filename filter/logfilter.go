@@ -6,8 +6,11 @@ import (
 	"log"
 	"regexp"
 	"strings"
+
+	"github.com/Financial-Times/log-collector/metrics"
 )
 
+// the built-in defaults of the rules
 var (
 	blacklistedProperties = []string{
 		"_GID",
@@ -23,7 +26,6 @@ var (
 		"_SYSTEMD_SLICE",
 		"_TRANSPORT",
 		"_UID",
-		"__CURSOR",
 		"__MONOTONIC_TIMESTAMP",
 		"_SELINUX_CONTEXT",
 		"__REALTIME_TIMESTAMP",
@@ -86,23 +88,47 @@ var (
 	mc         clusterService
 )
 
+// Decoder reads the log messages one by one, returning io.EOF once there are no more
+type Decoder interface {
+	Decode(m map[string]interface{}) error
+}
+
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (d jsonDecoder) Decode(m map[string]interface{}) error {
+	return d.dec.Decode(&m)
+}
+
+// NewJSONDecoder reads the log messages written by journalctl --output=json
+func NewJSONDecoder(r io.Reader) Decoder {
+	return jsonDecoder{json.NewDecoder(r)}
+}
+
 // Filters & enhances the JSON log messages that come into the reader, and writes the resulted log messages to the writer.
 func Filter(r io.Reader, w io.Writer) {
+	FilterEvents(NewJSONDecoder(r), w)
+}
+
+// FilterEvents filters & enhances the log messages read by the decoder, and writes the resulted log messages to the writer as JSON.
+func FilterEvents(dec Decoder, w io.Writer) {
 	defer log.Println("Log filter completed")
 
 	mc = newMonitoredClusterService(DNSAddress, Env)
 
-	dec := json.NewDecoder(r)
 	enc := json.NewEncoder(w)
 	for {
 		m := make(map[string]interface{})
-		err := dec.Decode(&m)
+		err := dec.Decode(m)
 		if err != nil {
 			if err == io.EOF {
 				return
 			}
 			panic(err)
 		}
+		metrics.LinesRead.Inc()
+		metrics.RecordInput()
 		keep := processMessage(m)
 		if keep {
 			enc.Encode(m)
@@ -111,46 +137,65 @@ func Filter(r io.Reader, w io.Writer) {
 }
 
 func processMessage(m map[string]interface{}) bool {
+	rules := currentRules()
+
+	serviceName := computeServiceName(m)
+	if !rules.allowed(m, serviceName) {
+		return drop("not_allowed")
+	}
+
 	unit := m["_SYSTEMD_UNIT"]
 	if unitString, ok := unit.(string); ok {
-		if blacklistedUnits[unitString] {
-			return false
+		if rules.units[unitString] {
+			return drop("unit")
 		}
 	}
 
-	serviceName := computeServiceName(m)
-	if blacklistedServices[serviceName] {
-		return false
+	if rules.services[serviceName] {
+		return drop("service")
 	}
 
 	syslogID := m["SYSLOG_IDENTIFIER"]
 	if syslogIDString, ok := syslogID.(string); ok {
-		if blacklistedSyslogIds[syslogIDString] {
-			return false
+		if rules.syslogIDs[syslogIDString] {
+			return drop("syslog_identifier")
 		}
 	}
 
 	containerTag := m["CONTAINER_TAG"]
 	if containerTagString, ok := containerTag.(string); ok {
-		if containsBlacklistedString(containerTagString, blacklistedContainerTags) {
-			return false
+		if containsBlacklistedString(containerTagString, rules.containerTags) {
+			return drop("container_tag")
 		}
 	}
 
 	message := fixBytesToString(m["MESSAGE"]).(string)
 
-	if containsBlacklistedString(message, blacklistedStrings) {
-		return false
+	if containsBlacklistedString(message, rules.messages) {
+		return drop("message")
 	}
 
 	message = hideAPIKeysInURLQueryParams(message)
 
-	munge(m, message)
-	removeBlacklistedProperties(m)
-	renameProperties(m)
+	extracted := munge(m, message)
+	if !rules.applyExpressions(m) {
+		return drop("expression")
+	}
+	extracted = append(extracted, "MESSAGE")
+	rules.pseudonymise(m, extracted)
+	rules.redact(m, extracted)
+	removeBlacklistedProperties(m, rules.removeProperties)
+	renameProperties(m, rules.renameProperties)
+	metrics.EventsKept.Inc()
 	return true
 }
 
+// drop counts the message as dropped for the given reason
+func drop(reason string) bool {
+	metrics.EventsDropped.WithLabelValues(reason).Inc()
+	return false
+}
+
 func containsBlacklistedString(message string, blacklistedStrings []string) bool {
 	for _, blacklistedString := range blacklistedStrings {
 		if strings.Contains(message, blacklistedString) {
@@ -174,7 +219,8 @@ func hideAPIKeysInURLQueryParams(msg string) string {
 	return msg
 }
 
-func munge(m map[string]interface{}, message string) {
+// munge adds the properties of the log message, and returns the names of the fields extracted from the message
+func munge(m map[string]interface{}, message string) []string {
 
 	m["platform"] = "up-k8s"
 	if Env != "" {
@@ -196,8 +242,9 @@ func munge(m map[string]interface{}, message string) {
 
 	ent, ok, format := extract(message)
 	if !ok {
-		return
+		return nil
 	}
+	metrics.EventsExtracted.WithLabelValues(format).Inc()
 
 	// hackity
 	j, err := json.Marshal(ent)
@@ -209,8 +256,10 @@ func munge(m map[string]interface{}, message string) {
 	if err != nil {
 		panic(err)
 	}
+	var extracted []string
 	for k, v := range entMap {
 		m[k] = v
+		extracted = append(extracted, k)
 	}
 
 	//avoid field duplication
@@ -221,6 +270,7 @@ func munge(m map[string]interface{}, message string) {
 	if m["monitoring_event"] == "true" {
 		m["active_cluster"], _ = mc.isActive()
 	}
+	return extracted
 }
 
 func computeServiceName(m map[string]interface{}) string {
@@ -234,7 +284,7 @@ func computeServiceName(m map[string]interface{}) string {
 func extractServiceName(containerTag interface{}) string {
 	containerNameSplitByUnderscores := splitByUnderscores(containerTag)
 
-	if len(containerNameSplitByUnderscores) >= 1 {
+	if len(containerNameSplitByUnderscores) > 1 {
 		stringArray := strings.Split(containerNameSplitByUnderscores[1], ".")
 		return stringArray[0]
 	}
@@ -242,6 +292,18 @@ func extractServiceName(containerTag interface{}) string {
 	return ""
 }
 
+// extractNamespace returns the Kubernetes namespace of the container, as given by the containers input or the container name
+func extractNamespace(m map[string]interface{}) string {
+	if namespace, ok := m["NAMESPACE"].(string); ok {
+		return namespace
+	}
+	containerNameSplitByUnderscores := splitByUnderscores(m["CONTAINER_NAME"])
+	if len(containerNameSplitByUnderscores) > 3 {
+		return containerNameSplitByUnderscores[3]
+	}
+	return ""
+}
+
 func extractPodName(containerTag interface{}) string {
 	containerNameSplitByUnderscores := splitByUnderscores(containerTag)
 
@@ -297,14 +359,14 @@ func fixNewLines(message string) string {
 	return strings.Replace(message, "|", "\n", -1)
 }
 
-func removeBlacklistedProperties(m map[string]interface{}) {
-	for _, p := range blacklistedProperties {
+func removeBlacklistedProperties(m map[string]interface{}, properties []string) {
+	for _, p := range properties {
 		delete(m, p)
 	}
 }
 
-func renameProperties(m map[string]interface{}) {
-	for p, r := range propertyMapping {
+func renameProperties(m map[string]interface{}, mapping map[string]string) {
+	for p, r := range mapping {
 		value := m[p]
 		if value != nil {
 			delete(m, p)
@@ -7,7 +7,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/log-collector/metrics"
 )
 
 func TestFixBytesToString(t *testing.T) {
@@ -57,11 +60,13 @@ var rawJSON = map[string]interface{}{
 	"__REALTIME_TIMESTAMP":  "realtime timestamp",
 }
 
+// the cursor is kept for the forwarder to checkpoint the delivered events
 var blacklistFilteredJSON = map[string]interface{}{
 	"MESSAGE":       "message",
 	"_HOSTNAME":     "hostname",
 	"_MACHINE_ID":   "machine",
 	"_SYSTEMD_UNIT": "system",
+	"__CURSOR":      "cursor",
 }
 
 var blacklistFilteredAndPropertiesRenamedJSON = map[string]interface{}{
@@ -69,17 +74,18 @@ var blacklistFilteredAndPropertiesRenamedJSON = map[string]interface{}{
 	"HOSTNAME":     "hostname",
 	"MACHINE_ID":   "machine",
 	"SYSTEMD_UNIT": "system",
+	"__CURSOR":     "cursor",
 }
 
 func TestApplyPropertyBlacklist(t *testing.T) {
-	removeBlacklistedProperties(rawJSON)
+	removeBlacklistedProperties(rawJSON, blacklistedProperties)
 	if !reflect.DeepEqual(rawJSON, blacklistFilteredJSON) {
 		t.Errorf("expected %v but got %v\n", blacklistFilteredJSON, rawJSON)
 	}
 }
 
 func TestShouldRenameProperties(t *testing.T) {
-	renameProperties(blacklistFilteredJSON)
+	renameProperties(blacklistFilteredJSON, propertyMapping)
 	if !reflect.DeepEqual(blacklistFilteredJSON, blacklistFilteredAndPropertiesRenamedJSON) {
 		t.Errorf("expected %v but got %v\n", blacklistFilteredAndPropertiesRenamedJSON, blacklistFilteredJSON)
 	}
@@ -175,6 +181,15 @@ func TestBlacklistedServices(t *testing.T) {
 	}
 }
 
+func TestDroppedMessagesAreCountedByReason(t *testing.T) {
+	before := testutil.ToFloat64(metrics.EventsDropped.WithLabelValues("service"))
+	m := make(map[string]interface{})
+	json.Unmarshal([]byte(msgWithContainerName("coco-diamond")), &m)
+
+	assert.False(t, processMessage(m))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.EventsDropped.WithLabelValues("service")))
+}
+
 func TestNotBlacklistedServices(t *testing.T) {
 	testCases := []struct {
 		jsonString string
@@ -331,3 +346,9 @@ func msgWithContainerName(service string) string {
 func msgWithContainerTag(containerTag string) string {
 	return fmt.Sprintf(`{"CONTAINER_ID":"03d1f4078733","CONTAINER_ID_FULL":"03d1f4078733f75f4505b07d1f8a3e8287ed497d9d54e0e785440cb969378ca3","CONTAINER_NAME":"k8s_not-black_not-black-79d574774-2rxrj_kube-system_a093cbca-fb5a-11e7-a6b6-06263dd4a414_6","CONTAINER_TAG":"%s@sha256:6ceb111a36020dc2124c0d7e3746088c20c7e3806a1075dd9e5fe1c42f744fff","HOSTNAME":"ip-10-172-40-164.eu-west-1.compute.internal","MACHINE_ID":"8d1225f40ee64cc7bcce2f549a41657c","MESSAGE":"I0119 15:38:05.932385 1 leaderelection.go:199] successfully renewed lease kube-system/cluster-autoscaler","POD_NAME":"cluster-autoscaler-79d574774-2rxrj","SERVICE_NAME":"whatever-api","SYSTEMD_UNIT":"docker.service","_SOURCE_REALTIME_TIMESTAMP":"1516376285932645","_SYSTEMD_INVOCATION_ID":"e3b2703c430f45e8a7075dbcf6b3a588","environment":"upp-prod-publish-eu","platform":"up-coco"}`, containerTag)
 }
+
+func TestExtractServiceNameWithContainerTagWithoutUnderscores(t *testing.T) {
+	if serviceName := extractServiceName("sidecar"); serviceName != "" {
+		t.Error("Expected empty string as service name when container tag without underscores is provided")
+	}
+}
@@ -0,0 +1,163 @@
+package filter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// minPseudonymKeyLength is the length of the SHA-256 output, below which the key is easier to guess than the hash
+const minPseudonymKeyLength = 32
+
+// pseudonymKey is the HMAC key of the pseudonyms, loaded on startup
+var pseudonymKey []byte
+
+// LoadPseudonymKey reads the key the user identifiers are pseudonymised with. Every node must use the same key, so that
+// a user maps to the same pseudonym everywhere.
+func LoadPseudonymKey(path string) error {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// the trailing newline of the secrets created with echo or an editor
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) < minPseudonymKeyLength {
+		return fmt.Errorf("the pseudonymisation key in %v is shorter than %v bytes", path, minPseudonymKeyLength)
+	}
+	pseudonymKey = key
+	return nil
+}
+
+// PseudonymiseRules replace the user identifiers with their HMAC, so that the activity of a user can be correlated without
+// keeping the identifiers, which can't be recovered without the key
+type PseudonymiseRules struct {
+	// Fields are replaced as a whole. A dot in a field name looks up the nested properties.
+	Fields []string `yaml:"fields"`
+	// Patterns are matched against MESSAGE and the fields extracted from it. The first group of the regular expression
+	// is replaced if it has any, the whole match otherwise.
+	Patterns []string `yaml:"patterns"`
+}
+
+func (p PseudonymiseRules) enabled() bool {
+	return len(p.Fields) > 0 || len(p.Patterns) > 0
+}
+
+func (p PseudonymiseRules) compile() ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for i, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pseudonymise.patterns[%v] is invalid: %v", i, err)
+		}
+		if re.MatchString("") {
+			return nil, fmt.Errorf("pseudonymise.patterns[%v] matches the empty string", i)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// pseudonym is the hex encoded HMAC-SHA256 of the value, truncated to 128 bits
+func pseudonym(value string) string {
+	mac := hmac.New(sha256.New, pseudonymKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// pseudonymise replaces the configured fields of the log message, and the pattern matches in the fields extracted from it
+func (r *ruleSet) pseudonymise(m map[string]interface{}, extracted []string) {
+	for _, field := range r.pseudonymiseFields {
+		pseudonymiseField(m, field)
+	}
+	if len(r.pseudonymisePatterns) == 0 {
+		return
+	}
+	for _, field := range extracted {
+		if v, found := m[field]; found {
+			m[field] = r.pseudonymiseMatches(v)
+		}
+	}
+}
+
+func pseudonymiseField(m map[string]interface{}, field string) {
+	if v, found := m[field]; found {
+		m[field] = pseudonymiseValue(v)
+		return
+	}
+	path := strings.Split(field, ".")
+	for _, key := range path[:len(path)-1] {
+		nested, ok := m[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = nested
+	}
+	last := path[len(path)-1]
+	if v, found := m[last]; found {
+		m[last] = pseudonymiseValue(v)
+	}
+}
+
+// pseudonymiseValue replaces the strings and numbers, including the ones of lists and nested properties
+func pseudonymiseValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil, bool:
+		return v
+	case map[string]interface{}:
+		for k, nested := range value {
+			value[k] = pseudonymiseValue(nested)
+		}
+		return value
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = pseudonymiseValue(nested)
+		}
+		return value
+	}
+	return pseudonym(toString(v))
+}
+
+func (r *ruleSet) pseudonymiseMatches(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		for _, re := range r.pseudonymisePatterns {
+			value = replaceMatches(re, value, pseudonym)
+		}
+		return value
+	case map[string]interface{}:
+		for k, nested := range value {
+			value[k] = r.pseudonymiseMatches(nested)
+		}
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = r.pseudonymiseMatches(nested)
+		}
+	}
+	return v
+}
+
+// replaceMatches replaces the first group of the matches of the regular expression, or the whole matches if it has none
+func replaceMatches(re *regexp.Regexp, value string, replace func(string) string) string {
+	matches := re.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) > 2 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+		b.WriteString(value[last:start])
+		b.WriteString(replace(value[start:end]))
+		last = end
+	}
+	b.WriteString(value[last:])
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPseudonymKey = "0123456789abcdef0123456789abcdef"
+
+func TestPseudonymiseTheAccessLogURLs(t *testing.T) {
+	defer usePseudonymKey(testPseudonymKey)()
+	defer UseRules(DefaultRules())
+	r, err := ParseRules([]byte(`pseudonymise:
+  patterns: ['/users/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})']
+`))
+	assert.NoError(t, err)
+	UseRules(r)
+
+	m := map[string]interface{}{"MESSAGE": `127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] "GET /users/6f1c2bd6-4b1e-4a8a-9d1a-2f3e4d5c6b7a/preferences HTTP/1.1" 200 53706 919 919`}
+	assert.True(t, processMessage(m))
+	assert.Equal(t, "/users/bbbdabe0c779ab29d8c3660b950bb9b0/preferences", m["url"])
+	assert.Contains(t, m["MESSAGE"], "GET /users/bbbdabe0c779ab29d8c3660b950bb9b0/preferences HTTP/1.1")
+	assert.NotContains(t, m["MESSAGE"], "6f1c2bd6")
+}
+
+func TestPseudonymiseFields(t *testing.T) {
+	defer usePseudonymKey(testPseudonymKey)()
+	r, err := ParseRules([]byte("pseudonymise:\n  fields: [user_id, user.name, missing.field]\n"))
+	assert.NoError(t, err)
+
+	m := map[string]interface{}{
+		"user_id": float64(42),
+		"user":    map[string]interface{}{"name": "jane", "admin": false},
+	}
+	r.compile().pseudonymise(m, nil)
+	assert.Equal(t, "3b12d0412db185c98ff58825ed4c81cf", m["user_id"])
+	assert.Equal(t, map[string]interface{}{"name": "8bfdd9ff2c70e0e9d314b991b9844132", "admin": false}, m["user"])
+	assert.NotContains(t, m, "missing")
+
+	other := map[string]interface{}{"user_id": "42"}
+	r.compile().pseudonymise(other, nil)
+	assert.Equal(t, m["user_id"], other["user_id"], "the same user should have the same pseudonym")
+}
+
+func TestPseudonymiseRequiresTheKey(t *testing.T) {
+	defer usePseudonymKey("")()
+	_, err := ParseRules([]byte("pseudonymise:\n  fields: [user_id]\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "requires the pseudonymisation key")
+	}
+	_, err = ParseRules([]byte("pseudonymise:\n  fields: []\n"))
+	assert.NoError(t, err)
+}
+
+func TestParseInvalidPseudonymiseRules(t *testing.T) {
+	defer usePseudonymKey(testPseudonymKey)()
+	invalid := map[string]string{
+		"pseudonymise:\n  fields: ['']\n":         "pseudonymise.fields[0] is empty",
+		"pseudonymise:\n  patterns: [a, '(']\n":   "pseudonymise.patterns[1] is invalid",
+		"pseudonymise:\n  patterns: ['[0-9]*']\n": "pseudonymise.patterns[0] matches the empty string",
+	}
+	for rules, expected := range invalid {
+		_, err := ParseRules([]byte(rules))
+		if assert.Error(t, err, rules) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
+
+func TestLoadPseudonymKey(t *testing.T) {
+	defer usePseudonymKey("")()
+	dir, err := ioutil.TempDir("", "key")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+
+	assert.Error(t, LoadPseudonymKey(path))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("too short\n"), 0600))
+	assert.Error(t, LoadPseudonymKey(path))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testPseudonymKey+"\n"), 0600))
+	assert.NoError(t, LoadPseudonymKey(path))
+	assert.Equal(t, testPseudonymKey, string(pseudonymKey), "the trailing newline should be left out")
+}
+
+// usePseudonymKey returns the function restoring the previous key
+func usePseudonymKey(key string) func() {
+	previous := pseudonymKey
+	pseudonymKey = []byte(key)
+	return func() { pseudonymKey = previous }
+}
@@ -0,0 +1,204 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Financial-Times/log-collector/metrics"
+)
+
+const (
+	redactMask      = "mask"
+	redactHash      = "hash"
+	redactDropField = "drop-field"
+)
+
+// Redaction redacts what a built-in detector, or a custom pattern, finds in MESSAGE and in the fields extracted from it
+type Redaction struct {
+	// Detector is the name of a built-in detector
+	Detector string `yaml:"detector"`
+	// Name and Pattern define a custom detector. The first group of the regular expression is redacted if it has any,
+	// the whole match otherwise.
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	// Mode is mask, the default, hash, which requires the pseudonymisation key, or drop-field
+	Mode string `yaml:"mode"`
+}
+
+func (r Redaction) String() string {
+	mode := r.Mode
+	if mode == "" {
+		mode = redactMask
+	}
+	if r.Detector != "" {
+		return r.Detector + " (" + mode + ")"
+	}
+	return fmt.Sprintf("%v =~ %q (%v)", r.Name, r.Pattern, mode)
+}
+
+func (r Redaction) compile() (redactor, error) {
+	mode := r.Mode
+	switch mode {
+	case "":
+		mode = redactMask
+	case redactMask, redactHash, redactDropField:
+	default:
+		return redactor{}, fmt.Errorf("unknown mode %q, expected %v, %v or %v", r.Mode, redactMask, redactHash, redactDropField)
+	}
+
+	if mode == redactHash && len(pseudonymKey) == 0 {
+		return redactor{}, fmt.Errorf("the hash mode requires the pseudonymisation key")
+	}
+
+	if r.Detector != "" {
+		if r.Name != "" || r.Pattern != "" {
+			return redactor{}, fmt.Errorf("the %v detector is built in, it has no name or pattern", r.Detector)
+		}
+		d, found := detectors[r.Detector]
+		if !found {
+			return redactor{}, fmt.Errorf("unknown detector %q, expected one of %v", r.Detector, strings.Join(detectorNames, ", "))
+		}
+		return redactor{name: r.Detector, re: d.re, valid: d.valid, mode: mode}, nil
+	}
+
+	if r.Name == "" || r.Pattern == "" {
+		return redactor{}, fmt.Errorf("either a detector, or a name and a pattern are required")
+	}
+	if _, found := detectors[r.Name]; found {
+		return redactor{}, fmt.Errorf("the name %v is taken by a built-in detector", r.Name)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return redactor{}, fmt.Errorf("invalid pattern: %v", err)
+	}
+	if re.MatchString("") {
+		return redactor{}, fmt.Errorf("the pattern matches the empty string")
+	}
+	return redactor{name: r.Name, re: re, mode: mode}, nil
+}
+
+type detector struct {
+	re *regexp.Regexp
+	// valid rules out the false positives of the regular expression
+	valid func(secret string) bool
+}
+
+// detectorNames are the built-in detectors. The Authorization headers should be redacted before the bearer tokens they contain.
+var detectorNames = []string{"authorization_header", "bearer_token", "jwt", "aws_access_key", "email", "card_number"}
+
+var detectors = map[string]detector{
+	"authorization_header": {re: regexp.MustCompile(`(?i)\b(?:proxy-)?authorization["']?\s*[:=]\s*["']?((?:(?:basic|bearer|digest|negotiate|token)\s+)?[^\s"',;]+)`)},
+	"bearer_token":         {re: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)},
+	"jwt":                  {re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	"aws_access_key":       {re: regexp.MustCompile(`\b(?:AKIA|ASIA|AIDA|AROA)[0-9A-Z]{16}\b`)},
+	"email":                {re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+	// the Visa, Mastercard, American Express and Discover numbers, optionally grouped with spaces or dashes
+	"card_number": {re: regexp.MustCompile(`\b(?:4\d{3}|5[1-5]\d{2}|2[2-7]\d{2}|3[47]\d{2}|6(?:011|5\d{2}))(?:[ -]?\d){9,15}\b`), valid: luhn},
+}
+
+// luhn checks the digits of a card number
+func luhn(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// redactor is the compiled form of a redaction
+type redactor struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(secret string) bool
+	mode  string
+}
+
+// redact returns the value with the secrets found masked or hashed, or whether the field is dropped instead
+func (r redactor) redact(value string) (string, bool) {
+	found := 0
+	redacted := replaceMatches(r.re, value, func(secret string) string {
+		if r.valid != nil && !r.valid(secret) {
+			return secret
+		}
+		found++
+		return r.replacement(secret)
+	})
+	if found == 0 {
+		return value, false
+	}
+	metrics.Redactions.WithLabelValues(r.name).Add(float64(found))
+	if r.mode == redactDropField {
+		return "", true
+	}
+	return redacted, false
+}
+
+func (r redactor) replacement(secret string) string {
+	if r.mode == redactHash {
+		// the same secret always has the same hash, so that the log messages can still be correlated, but it is keyed
+		// like the pseudonyms so that the secrets can't be guessed back from their hash
+		return "[HASHED:" + r.name + ":" + pseudonym(secret) + "]"
+	}
+	return "[REDACTED:" + r.name + "]"
+}
+
+// redact applies the redactions to the fields of the log message, and to the strings nested in them
+func (r *ruleSet) redact(m map[string]interface{}, fields []string) {
+	if len(r.redactors) == 0 {
+		return
+	}
+	for _, field := range fields {
+		v, found := m[field]
+		if !found {
+			continue
+		}
+		if redacted, dropped := r.redactValue(v); dropped {
+			delete(m, field)
+		} else {
+			m[field] = redacted
+		}
+	}
+}
+
+func (r *ruleSet) redactValue(v interface{}) (interface{}, bool) {
+	switch value := v.(type) {
+	case string:
+		for _, redactor := range r.redactors {
+			var dropped bool
+			if value, dropped = redactor.redact(value); dropped {
+				return nil, true
+			}
+		}
+		return value, false
+	case map[string]interface{}:
+		for k, nested := range value {
+			if redacted, dropped := r.redactValue(nested); dropped {
+				delete(value, k)
+			} else {
+				value[k] = redacted
+			}
+		}
+	case []interface{}:
+		kept := value[:0]
+		for _, nested := range value {
+			if redacted, dropped := r.redactValue(nested); !dropped {
+				kept = append(kept, redacted)
+			}
+		}
+		return kept, false
+	}
+	return v, false
+}
@@ -0,0 +1,186 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rulesFile is the file the rules in use were loaded from, with its content at the time
+var rulesFile struct {
+	sync.Mutex
+	path    string
+	content []byte
+}
+
+// UseRulesFile loads the rules file and filters the log messages with its rules from now on. The file is reloaded by
+// ReloadRules and WatchRules.
+func UseRulesFile(path string) error {
+	rulesFile.Lock()
+	defer rulesFile.Unlock()
+	r, content, err := readRules(path)
+	if err != nil {
+		return err
+	}
+	UseRules(r)
+	rulesFile.path, rulesFile.content = path, content
+	return nil
+}
+
+// ReloadRules loads the rules file again, e.g. on SIGHUP. Invalid rules are logged and the rules in use are kept.
+func ReloadRules() {
+	reloadRules(true)
+}
+
+// WatchRules reloads the rules file whenever its content changes, checking it every interval until quit is closed
+func WatchRules(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloadRules(false)
+		case <-quit:
+			return
+		}
+	}
+}
+
+func reloadRules(requested bool) {
+	rulesFile.Lock()
+	defer rulesFile.Unlock()
+	path := rulesFile.path
+	if path == "" {
+		if requested {
+			log.Println("No rules file to reload, the built-in rules are in use")
+		}
+		return
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to reload the rules file %v, keeping the rules in use: %v\n", path, err)
+		return
+	}
+	if bytes.Equal(content, rulesFile.content) {
+		if requested {
+			log.Printf("Rules file %v unchanged\n", path)
+		}
+		return
+	}
+	// remembered even when invalid, so that the same error is only logged once
+	rulesFile.content = content
+	r, err := ParseRules(content)
+	if err != nil {
+		log.Printf("Invalid rules file %v, keeping the rules in use: %v\n", path, err)
+		return
+	}
+	previous := currentRules().rules
+	UseRules(r)
+	changes := diffRules(previous, r)
+	if len(changes) == 0 {
+		log.Printf("Reloaded the rules file %v, the rules are unchanged\n", path)
+		return
+	}
+	log.Printf("Reloaded the rules file %v: %v\n", path, strings.Join(changes, "; "))
+}
+
+// diffRules describes the changes between the rules
+func diffRules(previous *Rules, r *Rules) []string {
+	var changes []string
+	lists := []struct {
+		name     string
+		previous []string
+		current  []string
+		ordered  bool //whether the rules are applied in order, so that reordering them is a change too
+	}{
+		{"allow.services", previous.Allow.Services, r.Allow.Services, false},
+		{"allow.namespaces", previous.Allow.Namespaces, r.Allow.Namespaces, false},
+		{"allow.units", previous.Allow.Units, r.Allow.Units, false},
+		{"allow.syslogIdentifiers", previous.Allow.SyslogIdentifiers, r.Allow.SyslogIdentifiers, false},
+		{"drop.units", previous.Drop.Units, r.Drop.Units, false},
+		{"drop.services", previous.Drop.Services, r.Drop.Services, false},
+		{"drop.syslogIdentifiers", previous.Drop.SyslogIdentifiers, r.Drop.SyslogIdentifiers, false},
+		{"drop.containerTags", previous.Drop.ContainerTags, r.Drop.ContainerTags, false},
+		{"drop.messages", previous.Drop.Messages, r.Drop.Messages, false},
+		{"removeProperties", previous.RemoveProperties, r.RemoveProperties, false},
+		{"expressions", expressionStrings(previous.Expressions), expressionStrings(r.Expressions), true},
+		{"redact", redactionStrings(previous.Redact), redactionStrings(r.Redact), true},
+		{"pseudonymise.fields", previous.Pseudonymise.Fields, r.Pseudonymise.Fields, false},
+		{"pseudonymise.patterns", previous.Pseudonymise.Patterns, r.Pseudonymise.Patterns, false},
+	}
+	for _, list := range lists {
+		added, removed := difference(list.current, list.previous), difference(list.previous, list.current)
+		if len(added) > 0 {
+			changes = append(changes, fmt.Sprintf("%v added %q", list.name, added))
+		}
+		if len(removed) > 0 {
+			changes = append(changes, fmt.Sprintf("%v removed %q", list.name, removed))
+		}
+		if list.ordered && len(added) == 0 && len(removed) == 0 && !equalLists(list.previous, list.current) {
+			changes = append(changes, fmt.Sprintf("%v reordered %q", list.name, list.current))
+		}
+	}
+
+	var renames []string
+	for from, to := range r.RenameProperties {
+		if before, found := previous.RenameProperties[from]; !found {
+			renames = append(renames, fmt.Sprintf("renameProperties added %v -> %v", from, to))
+		} else if before != to {
+			renames = append(renames, fmt.Sprintf("renameProperties changed %v -> %v to %v -> %v", from, before, from, to))
+		}
+	}
+	for from, to := range previous.RenameProperties {
+		if _, found := r.RenameProperties[from]; !found {
+			renames = append(renames, fmt.Sprintf("renameProperties removed %v -> %v", from, to))
+		}
+	}
+	sort.Strings(renames)
+	return append(changes, renames...)
+}
+
+func expressionStrings(expressions []ExpressionRule) []string {
+	var s []string
+	for _, e := range expressions {
+		s = append(s, e.String())
+	}
+	return s
+}
+
+func redactionStrings(redactions []Redaction) []string {
+	var s []string
+	for _, r := range redactions {
+		s = append(s, r.String())
+	}
+	return s
+}
+
+// equalLists tells whether the lists hold the same values in the same order
+func equalLists(values []string, others []string) bool {
+	if len(values) != len(others) {
+		return false
+	}
+	for i := range values {
+		if values[i] != others[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// difference returns the values missing from the others, in their order
+func difference(values []string, others []string) []string {
+	known := set(others)
+	var missing []string
+	for _, v := range values {
+		if !known[v] {
+			missing = append(missing, v)
+			known[v] = true
+		}
+	}
+	return missing
+}
@@ -0,0 +1,122 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRules(t *testing.T) {
+	previous, err := ParseRules([]byte("drop:\n  services: [a, b]\nremoveProperties: [_GID]\nrenameProperties: {_HOSTNAME: HOSTNAME, SOURCE: source}\n"))
+	assert.NoError(t, err)
+	r, err := ParseRules([]byte("drop:\n  services: [b, c]\nremoveProperties: []\nrenameProperties: {_HOSTNAME: host, _UID: UID}\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`drop.services added ["c"]`,
+		`drop.services removed ["a"]`,
+		`removeProperties removed ["_GID"]`,
+		"renameProperties added _UID -> UID",
+		"renameProperties changed _HOSTNAME -> HOSTNAME to _HOSTNAME -> host",
+		"renameProperties removed SOURCE -> source",
+	}, diffRules(previous, r))
+	assert.Empty(t, diffRules(r, r))
+}
+
+func TestDiffRulesReportsReorderedExpressions(t *testing.T) {
+	previous, err := ParseRules([]byte("expressions:\n  - when: 'level == \"debug\"'\n    action: drop\n  - when: 'level'\n    action: keep\n"))
+	assert.NoError(t, err)
+	r, err := ParseRules([]byte("expressions:\n  - when: 'level'\n    action: keep\n  - when: 'level == \"debug\"'\n    action: drop\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{`expressions reordered ["keep when level" "drop when level == \"debug\""]`}, diffRules(previous, r),
+		"the first matching expression decides, so their order matters")
+}
+
+func TestWatchRulesReloadsTheChangedFile(t *testing.T) {
+	path := writeRulesFile(t, "drop:\n  services: [first]\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	defer resetRules()
+	assert.NoError(t, UseRulesFile(path))
+	assert.False(t, processMessage(serviceMessage("first")))
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go WatchRules(10*time.Millisecond, quit)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("drop:\n  services: [second]\n"), 0644))
+	for deadline := time.Now().Add(2 * time.Second); !currentRules().services["second"] && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, processMessage(serviceMessage("first")))
+	assert.False(t, processMessage(serviceMessage("second")))
+}
+
+func TestReloadRulesKeepsTheRulesInUseWhenInvalid(t *testing.T) {
+	path := writeRulesFile(t, "drop:\n  services: [first]\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	defer resetRules()
+	assert.NoError(t, UseRulesFile(path))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("drop:\n  services: first\n"), 0644))
+	ReloadRules()
+	assert.True(t, currentRules().services["first"])
+
+	assert.NoError(t, os.Remove(path))
+	ReloadRules()
+	assert.True(t, currentRules().services["first"])
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("drop:\n  services: [second]\n"), 0644))
+	ReloadRules()
+	assert.True(t, currentRules().services["second"], "the rules should be reloaded once fixed")
+}
+
+func TestRulesAreSwappedWhileFiltering(t *testing.T) {
+	defer resetRules()
+	first, _ := ParseRules([]byte("drop:\n  messages: [first]\n"))
+	second, _ := ParseRules([]byte("drop:\n  messages: [second]\n"))
+
+	UseRules(first)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				UseRules(first)
+			} else {
+				UseRules(second)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		kept := processMessage(map[string]interface{}{"MESSAGE": "first and second"})
+		assert.False(t, kept, "every message should be filtered with either rule set")
+	}
+	wg.Wait()
+}
+
+func serviceMessage(service string) map[string]interface{} {
+	return map[string]interface{}{"CONTAINER_NAME": "k8s_" + service + "_" + service + "-pod_default", "MESSAGE": "message"}
+}
+
+func writeRulesFile(t *testing.T, rules string) string {
+	dir, err := ioutil.TempDir("", "rules")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "rules.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(rules), 0644))
+	return path
+}
+
+func resetRules() {
+	UseRules(DefaultRules())
+	rulesFile.Lock()
+	rulesFile.path, rulesFile.content = "", nil
+	rulesFile.Unlock()
+}
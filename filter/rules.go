@@ -0,0 +1,378 @@
+package filter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rules decide which log messages are dropped, and how the properties of the kept ones are cleaned up.
+// They are loaded from a YAML or JSON rules file, where the lists and mappings left out keep their built-in defaults
+// while the empty ones clear them.
+type Rules struct {
+	// Allow turns the allowlist mode on when any of its lists isn't empty
+	Allow AllowRules `yaml:"allow"`
+	Drop  DropRules  `yaml:"drop"`
+	// RemoveProperties are deleted from the kept log messages
+	RemoveProperties []string `yaml:"removeProperties"`
+	// RenameProperties maps the properties to their new name, applied after removing properties
+	RenameProperties map[string]string `yaml:"renameProperties"`
+	// Expressions are applied in order to the log messages that passed the drop rules, once their fields are extracted
+	Expressions []ExpressionRule `yaml:"expressions"`
+	// Redact are applied in order to MESSAGE and the fields extracted from it, nothing is redacted unless they are listed
+	Redact []Redaction `yaml:"redact"`
+	// Pseudonymise is applied before Redact, and requires the pseudonymisation key
+	Pseudonymise PseudonymiseRules `yaml:"pseudonymise"`
+}
+
+const (
+	actionKeep = "keep"
+	actionDrop = "drop"
+	actionTag  = "tag"
+)
+
+// ExpressionRule applies its action to the log messages matching its expression. The first matching keep or drop rule decides
+// whether the log message is kept, while tag rules add their tags to the log message and carry on.
+type ExpressionRule struct {
+	When   string            `yaml:"when"`
+	Action string            `yaml:"action"`
+	Tags   map[string]string `yaml:"tags"`
+}
+
+func (e ExpressionRule) String() string {
+	if len(e.Tags) == 0 {
+		return e.Action + " when " + e.When
+	}
+	return fmt.Sprintf("%v %v when %v", e.Action, e.Tags, e.When)
+}
+
+func (e ExpressionRule) compile() (compiledExpression, error) {
+	switch e.Action {
+	case actionKeep, actionDrop:
+		if len(e.Tags) > 0 {
+			return compiledExpression{}, fmt.Errorf("only the %v action has tags", actionTag)
+		}
+	case actionTag:
+		if len(e.Tags) == 0 {
+			return compiledExpression{}, fmt.Errorf("the %v action requires tags", actionTag)
+		}
+	default:
+		return compiledExpression{}, fmt.Errorf("unknown action %q, expected %v, %v or %v", e.Action, actionKeep, actionDrop, actionTag)
+	}
+	if e.When == "" {
+		return compiledExpression{}, fmt.Errorf("the when expression is required")
+	}
+	matches, err := compileExpression(e.When)
+	if err != nil {
+		return compiledExpression{}, fmt.Errorf("invalid when expression: %v", err)
+	}
+	return compiledExpression{matches: matches, action: e.Action, tags: e.Tags}, nil
+}
+
+type compiledExpression struct {
+	matches predicate
+	action  string
+	tags    map[string]string
+}
+
+// AllowRules list what the log messages kept in allowlist mode are recognised by. The log messages matching none of them are
+// dropped, while the drop rules still apply to the ones matching any of them.
+type AllowRules struct {
+	// Services are the names of the Kubernetes services, as in the container names
+	Services []string `yaml:"services"`
+	// Namespaces are the Kubernetes namespaces
+	Namespaces []string `yaml:"namespaces"`
+	// Units are the systemd units
+	Units []string `yaml:"units"`
+	// SyslogIdentifiers are the syslog identifiers
+	SyslogIdentifiers []string `yaml:"syslogIdentifiers"`
+}
+
+func (a AllowRules) enabled() bool {
+	return len(a.Services) > 0 || len(a.Namespaces) > 0 || len(a.Units) > 0 || len(a.SyslogIdentifiers) > 0
+}
+
+// DropRules list what the dropped log messages are recognised by
+type DropRules struct {
+	// Units are the systemd units whose messages are dropped
+	Units []string `yaml:"units"`
+	// Services are the names of the Kubernetes services, as in the container names, whose messages are dropped
+	Services []string `yaml:"services"`
+	// SyslogIdentifiers are the syslog identifiers whose messages are dropped
+	SyslogIdentifiers []string `yaml:"syslogIdentifiers"`
+	// ContainerTags drop the messages whose container tag contains any of them
+	ContainerTags []string `yaml:"containerTags"`
+	// Messages drop the messages containing any of them
+	Messages []string `yaml:"messages"`
+}
+
+// DefaultRules are the built-in rules
+func DefaultRules() *Rules {
+	return &Rules{
+		Drop: DropRules{
+			Units:             keys(blacklistedUnits),
+			Services:          keys(blacklistedServices),
+			SyslogIdentifiers: keys(blacklistedSyslogIds),
+			ContainerTags:     append([]string{}, blacklistedContainerTags...),
+			Messages:          append([]string{}, blacklistedStrings...),
+		},
+		RemoveProperties: append([]string{}, blacklistedProperties...),
+		RenameProperties: copyMapping(propertyMapping),
+	}
+}
+
+// LoadRules reads and validates the rules file
+func LoadRules(path string) (*Rules, error) {
+	r, _, err := readRules(path)
+	return r, err
+}
+
+// readRules also returns the content of the rules file
+func readRules(path string) (*Rules, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := ParseRules(data)
+	if err != nil {
+		return nil, data, fmt.Errorf("invalid rules file %v: %v", path, err)
+	}
+	return r, data, nil
+}
+
+// ParseRules parses and validates rules written in YAML, or JSON which is valid YAML too. Unknown keys are rejected.
+func ParseRules(data []byte) (*Rules, error) {
+	r := &Rules{}
+	if err := yaml.UnmarshalStrict(data, r); err != nil {
+		return nil, err
+	}
+	defaults := DefaultRules()
+	if r.Drop.Units == nil {
+		r.Drop.Units = defaults.Drop.Units
+	}
+	if r.Drop.Services == nil {
+		r.Drop.Services = defaults.Drop.Services
+	}
+	if r.Drop.SyslogIdentifiers == nil {
+		r.Drop.SyslogIdentifiers = defaults.Drop.SyslogIdentifiers
+	}
+	if r.Drop.ContainerTags == nil {
+		r.Drop.ContainerTags = defaults.Drop.ContainerTags
+	}
+	if r.Drop.Messages == nil {
+		r.Drop.Messages = defaults.Drop.Messages
+	}
+	if r.RemoveProperties == nil {
+		r.RemoveProperties = defaults.RemoveProperties
+	}
+	if r.RenameProperties == nil {
+		r.RenameProperties = defaults.RenameProperties
+	}
+	if r.Expressions == nil {
+		r.Expressions = defaults.Expressions
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Validate reports the first invalid rule
+func (r *Rules) Validate() error {
+	lists := []struct {
+		name   string
+		values []string
+	}{
+		{"allow.services", r.Allow.Services},
+		{"allow.namespaces", r.Allow.Namespaces},
+		{"allow.units", r.Allow.Units},
+		{"allow.syslogIdentifiers", r.Allow.SyslogIdentifiers},
+		{"drop.units", r.Drop.Units},
+		{"drop.services", r.Drop.Services},
+		{"drop.syslogIdentifiers", r.Drop.SyslogIdentifiers},
+		{"drop.containerTags", r.Drop.ContainerTags},
+		{"drop.messages", r.Drop.Messages},
+		{"removeProperties", r.RemoveProperties},
+		{"pseudonymise.fields", r.Pseudonymise.Fields},
+		{"pseudonymise.patterns", r.Pseudonymise.Patterns},
+	}
+	for _, list := range lists {
+		for i, v := range list.values {
+			if v == "" {
+				// an empty substring would drop every message
+				return fmt.Errorf("%v[%v] is empty", list.name, i)
+			}
+		}
+	}
+
+	removed := make(map[string]bool)
+	for _, p := range r.RemoveProperties {
+		removed[p] = true
+	}
+	renamedTo := make(map[string]string)
+	for from, to := range r.RenameProperties {
+		if from == "" || to == "" {
+			return fmt.Errorf("renameProperties can't rename %q to %q, property names can't be empty", from, to)
+		}
+		if removed[from] {
+			return fmt.Errorf("renameProperties.%v is removed by removeProperties before it can be renamed", from)
+		}
+		if other, found := renamedTo[to]; found {
+			return fmt.Errorf("renameProperties renames both %v and %v to %v", other, from, to)
+		}
+		renamedTo[to] = from
+	}
+
+	for i, e := range r.Expressions {
+		if _, err := e.compile(); err != nil {
+			return fmt.Errorf("expressions[%v]: %v", i, err)
+		}
+	}
+
+	for i, redaction := range r.Redact {
+		if _, err := redaction.compile(); err != nil {
+			return fmt.Errorf("redact[%v]: %v", i, err)
+		}
+	}
+
+	if _, err := r.Pseudonymise.compile(); err != nil {
+		return err
+	}
+	if r.Pseudonymise.enabled() && len(pseudonymKey) == 0 {
+		return fmt.Errorf("pseudonymise requires the pseudonymisation key")
+	}
+	return nil
+}
+
+// ruleSet is the form of the rules used for filtering
+type ruleSet struct {
+	rules            *Rules
+	allowlist        bool
+	allowServices    map[string]bool
+	allowNamespaces  map[string]bool
+	allowUnits       map[string]bool
+	allowSyslogIDs   map[string]bool
+	units            map[string]bool
+	services         map[string]bool
+	syslogIDs        map[string]bool
+	containerTags    []string
+	messages         []string
+	removeProperties []string
+	renameProperties map[string]string
+	expressions      []compiledExpression
+	redactors        []redactor
+	// the pseudonymise rules
+	pseudonymiseFields   []string
+	pseudonymisePatterns []*regexp.Regexp
+}
+
+// compile expects validated rules
+func (r *Rules) compile() *ruleSet {
+	var expressions []compiledExpression
+	for _, e := range r.Expressions {
+		compiled, _ := e.compile()
+		expressions = append(expressions, compiled)
+	}
+	var redactors []redactor
+	for _, redaction := range r.Redact {
+		compiled, _ := redaction.compile()
+		redactors = append(redactors, compiled)
+	}
+	patterns, _ := r.Pseudonymise.compile()
+	return &ruleSet{
+		rules:            r,
+		allowlist:        r.Allow.enabled(),
+		allowServices:    set(r.Allow.Services),
+		allowNamespaces:  set(r.Allow.Namespaces),
+		allowUnits:       set(r.Allow.Units),
+		allowSyslogIDs:   set(r.Allow.SyslogIdentifiers),
+		units:            set(r.Drop.Units),
+		services:         set(r.Drop.Services),
+		syslogIDs:        set(r.Drop.SyslogIdentifiers),
+		containerTags:    r.Drop.ContainerTags,
+		messages:         r.Drop.Messages,
+		removeProperties: r.RemoveProperties,
+		renameProperties: r.RenameProperties,
+		expressions:      expressions,
+		redactors:        redactors,
+
+		pseudonymiseFields:   r.Pseudonymise.Fields,
+		pseudonymisePatterns: patterns,
+	}
+}
+
+// allowed tells whether the log message matches any of the allow rules
+func (r *ruleSet) allowed(m map[string]interface{}, serviceName string) bool {
+	if !r.allowlist {
+		return true
+	}
+	unit, _ := m["_SYSTEMD_UNIT"].(string)
+	syslogID, _ := m["SYSLOG_IDENTIFIER"].(string)
+	return r.allowServices[serviceName] || r.allowNamespaces[extractNamespace(m)] || r.allowUnits[unit] || r.allowSyslogIDs[syslogID]
+}
+
+// applyExpressions tags the log message, and tells whether it is kept
+func (r *ruleSet) applyExpressions(m map[string]interface{}) bool {
+	for _, e := range r.expressions {
+		if !e.matches(m) {
+			continue
+		}
+		switch e.action {
+		case actionKeep:
+			return true
+		case actionDrop:
+			return false
+		default:
+			for k, v := range e.tags {
+				m[k] = v
+			}
+		}
+	}
+	return true
+}
+
+// activeRules holds the *ruleSet the log messages are filtered with. Every log message is filtered with the rules in use
+// when its filtering starts, so that swapping them never applies two rule sets to the same message.
+var activeRules atomic.Value
+
+func init() {
+	activeRules.Store(DefaultRules().compile())
+}
+
+func currentRules() *ruleSet {
+	return activeRules.Load().(*ruleSet)
+}
+
+// UseRules filters the log messages with the rules from now on
+func UseRules(r *Rules) {
+	activeRules.Store(r.compile())
+}
+
+func keys(m map[string]bool) []string {
+	var values []string
+	for k := range m {
+		values = append(values, k)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func set(values []string) map[string]bool {
+	m := make(map[string]bool)
+	for _, v := range values {
+		m[v] = true
+	}
+	return m
+}
+
+func copyMapping(m map[string]string) map[string]string {
+	c := make(map[string]string)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRulesKeepsTheDefaultsOfTheRulesLeftOut(t *testing.T) {
+	r, err := ParseRules([]byte(`
+drop:
+  services:
+    - noisy-service
+  messages: []
+renameProperties:
+  _HOSTNAME: host
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"noisy-service"}, r.Drop.Services)
+	assert.Empty(t, r.Drop.Messages, "an empty list should clear the default")
+	assert.Equal(t, map[string]string{"_HOSTNAME": "host"}, r.RenameProperties)
+
+	defaults := DefaultRules()
+	assert.Equal(t, defaults.Drop.Units, r.Drop.Units)
+	assert.Equal(t, defaults.Drop.SyslogIdentifiers, r.Drop.SyslogIdentifiers)
+	assert.Equal(t, defaults.Drop.ContainerTags, r.Drop.ContainerTags)
+	assert.Equal(t, defaults.RemoveProperties, r.RemoveProperties)
+}
+
+func TestParseRulesInJSON(t *testing.T) {
+	r, err := ParseRules([]byte(`{"drop": {"units": ["noisy.service"]}, "removeProperties": ["_PID"]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"noisy.service"}, r.Drop.Units)
+	assert.Equal(t, []string{"_PID"}, r.RemoveProperties)
+}
+
+func TestParseRulesOfAnEmptyFile(t *testing.T) {
+	r, err := ParseRules([]byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRules(), r)
+}
+
+func TestParseInvalidRules(t *testing.T) {
+	invalid := map[string]string{
+		"drop:\n  unit: [a]\n":                                      "field unit not found",
+		"drop:\n  units: a\n":                                       "cannot unmarshal",
+		"drop:\n  messages: [a, '']\n":                              "drop.messages[1] is empty",
+		"removeProperties: [_PID]\nrenameProperties: {_PID: pid}\n": "renameProperties._PID is removed",
+		"renameProperties: {a: c, b: c}\n":                          "to c",
+		"renameProperties: {a: ''}\n":                               "can't be empty",
+	}
+	for rules, expected := range invalid {
+		_, err := ParseRules([]byte(rules))
+		if assert.Error(t, err, rules) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.yaml")
+
+	_, err = LoadRules(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("drop:\n  services: [1, [2]]\n"), 0644))
+	_, err = LoadRules(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), path, "the error should tell which file is invalid")
+	}
+}
+
+func TestMessagesAreFilteredWithTheRulesInUse(t *testing.T) {
+	defer UseRules(DefaultRules())
+	r, err := ParseRules([]byte("drop:\n  messages: [secret]\nremoveProperties: [_PID]\n"))
+	assert.NoError(t, err)
+	UseRules(r)
+
+	assert.False(t, processMessage(map[string]interface{}{"MESSAGE": "a secret message"}))
+	assert.True(t, processMessage(map[string]interface{}{"MESSAGE": "__health"}), "the default messages should not be dropped anymore")
+
+	m := map[string]interface{}{"MESSAGE": "kept", "_PID": "42", "_UID": "0"}
+	assert.True(t, processMessage(m))
+	assert.Nil(t, m["_PID"])
+	assert.Equal(t, "0", m["_UID"], "only the properties of the rules in use should be removed")
+}
+
+func TestAllowlistMode(t *testing.T) {
+	defer UseRules(DefaultRules())
+	r, err := ParseRules([]byte(`
+allow:
+  services: [content-public-read, cluster-autoscaler]
+  namespaces: [pac]
+  units: [kubelet.service]
+  syslogIdentifiers: [sshd]
+drop:
+  messages: [__gtg]
+`))
+	assert.NoError(t, err)
+	UseRules(r)
+
+	kept := []map[string]interface{}{
+		{"CONTAINER_NAME": "k8s_content-public-read_content-public-read-7b9d6d8c5b-xk4dz_default_e1ce147e_0", "MESSAGE": "allowed service"},
+		{"CONTAINER_NAME": "k8s_pac-api_pac-api-7b9d6d8c5b-xk4dz_pac_e1ce147e_0", "MESSAGE": "allowed namespace"},
+		{"NAMESPACE": "pac", "CONTAINER_NAME": "k8s_pac-api_pac-api-7b9d6d8c5b-xk4dz_pac", "MESSAGE": "namespace of the containers input"},
+		{"_SYSTEMD_UNIT": "kubelet.service", "MESSAGE": "allowed unit"},
+		{"SYSLOG_IDENTIFIER": "sshd", "MESSAGE": "allowed syslog identifier"},
+	}
+	for _, m := range kept {
+		assert.True(t, processMessage(m), m["MESSAGE"])
+	}
+
+	dropped := []map[string]interface{}{
+		{"CONTAINER_NAME": "k8s_other_other-7b9d6d8c5b-xk4dz_default_e1ce147e_0", "MESSAGE": "not allowed"},
+		{"_SYSTEMD_UNIT": "docker.service", "MESSAGE": "not allowed"},
+		{"MESSAGE": "nothing to allow it by"},
+		{"_SYSTEMD_UNIT": "kubelet.service", "MESSAGE": "GET /__gtg"},
+	}
+	for _, m := range dropped {
+		assert.False(t, processMessage(m), m["MESSAGE"])
+	}
+
+	assert.False(t, processMessage(map[string]interface{}{"CONTAINER_NAME": "k8s_cluster-autoscaler_cluster-autoscaler-7b9d6d8c5b-xk4dz_kube-system_e1ce147e_0", "MESSAGE": "allowed"}),
+		"the default drop rules should still apply to the allowed services")
+}
+
+func TestAllowlistModeIsOffByDefault(t *testing.T) {
+	r, err := ParseRules([]byte("allow:\n  services: []\n"))
+	assert.NoError(t, err)
+	assert.False(t, r.compile().allowlist)
+	assert.True(t, r.compile().allowed(map[string]interface{}{"MESSAGE": "anything"}, ""))
+}
@@ -0,0 +1,239 @@
+package forwarder
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
+)
+
+// What a sink does with a new batch when its queue is full
+const (
+	// block waits for room in the queue, stalling the input
+	backpressureBlock = "block"
+	// drop-oldest drops the oldest queued batch to make room for the new one
+	backpressureDropOldest = "drop-oldest"
+	// drop-newest drops the new batch
+	backpressureDropNewest = "drop-newest"
+	// drop-by-level drops the events of the levels listed by DropLevels while any queue is full, and blocks otherwise
+	backpressureDropByLevel = "drop-by-level"
+	// spill writes the new batch to a spool of the sink, fed back into the queue once it has room
+	backpressureSpill = "spill"
+)
+
+var (
+	Backpressure  string
+	DropLevels    string
+	levelRegex    = regexp.MustCompile(`"(?i:level)":\s*"([^"]*)"`)
+	droppedEvents int64
+	spillInterval = time.Second
+)
+
+// ValidateBackpressure checks that the policy is one of the supported ones
+func ValidateBackpressure(policy string) error {
+	switch policy {
+	case backpressureBlock, backpressureDropOldest, backpressureDropNewest, backpressureDropByLevel, backpressureSpill:
+		return nil
+	}
+	return fmt.Errorf("unknown backpressure policy %q", policy)
+}
+
+// DroppedEvents returns the number of events dropped by level since startup
+func DroppedEvents() int64 {
+	return atomic.LoadInt64(&droppedEvents)
+}
+
+func parseLevels(levels string) map[string]bool {
+	parsed := make(map[string]bool)
+	for _, level := range strings.Split(levels, ",") {
+		if level = strings.ToLower(strings.TrimSpace(level)); level != "" {
+			parsed[level] = true
+		}
+	}
+	return parsed
+}
+
+// dropByLevel returns the events whose level isn't one of the given ones. Events without a level are kept.
+func dropByLevel(eventlist []string, levels map[string]bool) []string {
+	kept := make([]string, 0, len(eventlist))
+	for _, e := range eventlist {
+		level := levelRegex.FindStringSubmatch(e)
+		if len(level) > 0 && levels[strings.ToLower(level[1])] {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if dropped := len(eventlist) - len(kept); dropped > 0 {
+		atomic.AddInt64(&droppedEvents, int64(dropped))
+		metrics.EventsDroppedByLevel.Add(float64(dropped))
+		log.Printf("Queue full, dropped %v events by level\n", dropped)
+	}
+	return kept
+}
+
+// offer queues the batch if there is room in the queue, without applying the backpressure policy
+func (s *sinkDispatch) offer(d *delivery) bool {
+	if s.spill != nil {
+		// the new batches go after the spilled ones
+		return false
+	}
+	select {
+	case s.inChan <- d:
+		s.updateQueueDepth()
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueue queues the batch for delivery, applying the backpressure policy of the sink if the queue is full
+func (s *sinkDispatch) enqueue(d *delivery) {
+	defer s.updateQueueDepth()
+	switch s.backpressure {
+	case backpressureDropNewest:
+		select {
+		case s.inChan <- d:
+		default:
+			log.Printf("Queue of %v full, dropping batch %v\n", s.name, d.batch.ID)
+			s.count(&s.stats.DroppedNewest, "dropped_newest")
+			d.done(true)
+		}
+	case backpressureDropOldest:
+		for {
+			select {
+			case s.inChan <- d:
+				return
+			default:
+			}
+			select {
+			case oldest := <-s.inChan:
+				log.Printf("Queue of %v full, dropping batch %v\n", s.name, oldest.batch.ID)
+				s.count(&s.stats.DroppedOldest, "dropped_oldest")
+				oldest.done(true)
+			default:
+			}
+		}
+	case backpressureSpill:
+		s.spill.enqueue(s, d)
+	default:
+		s.inChan <- d
+	}
+}
+
+func (s *sinkDispatch) saturated() bool {
+	return len(s.inChan) >= cap(s.inChan)
+}
+
+// spiller keeps the batches that didn't fit in the queue of a sink in a spool of its own, so that they take no memory.
+// While there are spilled batches, the new ones are spilled too, so that the batches are still delivered in order.
+type spiller struct {
+	sync.Mutex
+	spool    *dirSpool
+	backlog  int
+	lastFed  string
+	quit     chan struct{}
+	finished chan struct{}
+}
+
+func newSpiller(sink string) (*spiller, error) {
+	sp, err := newSpool(filepath.Join(SpoolDir, "spill", sink))
+	if err != nil {
+		return nil, err
+	}
+	spill := &spiller{spool: sp.(*dirSpool)}
+	// batches spilled by a previous run
+	names, err := spill.spool.names()
+	if err != nil {
+		return nil, err
+	}
+	spill.backlog = len(names)
+	return spill, nil
+}
+
+func (spill *spiller) enqueue(s *sinkDispatch, d *delivery) {
+	spill.Lock()
+	defer spill.Unlock()
+	if spill.backlog == 0 {
+		select {
+		case s.inChan <- d:
+			return
+		default:
+		}
+	}
+	if err := spill.spool.Write(d.batch); err != nil {
+		log.Printf("Failed to spill batch %v of %v, waiting for the queue instead: %v\n", d.batch.ID, s.name, err)
+		spill.Unlock()
+		s.inChan <- d
+		spill.Lock()
+		return
+	}
+	spill.backlog++
+	s.count(&s.stats.Spilled, "spilled")
+	// the spilled batch no longer depends on the main spool
+	d.handOver()
+}
+
+// start feeds the spilled batches back into the queue of the sink as it drains
+func (spill *spiller) start(s *sinkDispatch) {
+	spill.quit = make(chan struct{})
+	spill.finished = make(chan struct{})
+	go func() {
+		defer close(spill.finished)
+		ticker := time.NewTicker(spillInterval)
+		defer ticker.Stop()
+		for {
+			spill.feed(s)
+			select {
+			case <-ticker.C:
+			case <-spill.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (spill *spiller) stop() {
+	close(spill.quit)
+	<-spill.finished
+}
+
+func (spill *spiller) feed(s *sinkDispatch) {
+	spill.Lock()
+	defer spill.Unlock()
+	if spill.backlog == 0 {
+		return
+	}
+	names, err := spill.spool.names()
+	if err != nil {
+		log.Printf("Failed to read the spilled batches of %v: %v\n", s.name, err)
+		return
+	}
+	for _, name := range names {
+		// the batches that were fed already stay in the spool until they are delivered
+		if name <= spill.lastFed {
+			continue
+		}
+		if s.saturated() {
+			return
+		}
+		b, err := spill.spool.read(name)
+		if err != nil {
+			log.Printf("Failed to read spilled batch %v of %v: %v\n", name, s.name, err)
+			return
+		}
+		spill.lastFed = name
+		spill.backlog--
+		if b != nil {
+			s.inChan <- &delivery{batch: b, spool: spill.spool, checkpoint: s.checkpoint, pending: 1}
+			s.updateQueueDepth()
+		}
+	}
+	// every file was fed, whatever is left over belongs to failed deliveries that are retried on restart
+	spill.backlog = 0
+}
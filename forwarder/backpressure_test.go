@@ -0,0 +1,107 @@
+package forwarder
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fullSinkDispatch returns a sink with a queue of a single batch and no workers, so that the queue stays full
+func fullSinkDispatch(name string, backpressure string) *sinkDispatch {
+	s := newSinkDispatch(name, &s3ServiceMock{}, nil, retryPolicy{})
+	s.backpressure = backpressure
+	s.inChan = make(chan *delivery, 1)
+	return s
+}
+
+func queuedBatchIDs(s *sinkDispatch) []string {
+	var ids []string
+	for len(s.inChan) > 0 {
+		ids = append(ids, (<-s.inChan).batch.ID)
+	}
+	return ids
+}
+
+func Test_Backpressure_DropNewest(t *testing.T) {
+	s := fullSinkDispatch("dropnewest", backpressureDropNewest)
+	before := Stats()["dropnewest"]
+	first, second := newBatch("first", time.Now()), newBatch("second", time.Now())
+
+	s.enqueue(&delivery{batch: first, spool: noopSpool{}, pending: 1})
+	s.enqueue(&delivery{batch: second, spool: noopSpool{}, pending: 1})
+
+	assert.Equal(t, []string{first.ID}, queuedBatchIDs(s))
+	assert.Equal(t, before.DroppedNewest+1, Stats()["dropnewest"].DroppedNewest)
+}
+
+func Test_Backpressure_DropOldest(t *testing.T) {
+	s := fullSinkDispatch("dropoldest", backpressureDropOldest)
+	before := Stats()["dropoldest"]
+	first, second := newBatch("first", time.Now()), newBatch("second", time.Now())
+
+	s.enqueue(&delivery{batch: first, spool: noopSpool{}, pending: 1})
+	s.enqueue(&delivery{batch: second, spool: noopSpool{}, pending: 1})
+
+	assert.Equal(t, []string{second.ID}, queuedBatchIDs(s))
+	assert.Equal(t, before.DroppedOldest+1, Stats()["dropoldest"].DroppedOldest)
+}
+
+func Test_Backpressure_SpillAndFeedBackInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(spoolDir string) { SpoolDir = spoolDir }(SpoolDir)
+	SpoolDir = dir
+
+	s := fullSinkDispatch("spilling", backpressureSpill)
+	s.spill, err = newSpiller("spilling")
+	assert.NoError(t, err)
+	before := Stats()["spilling"]
+	first, second, third := newBatch("first", time.Now()), newBatch("second", time.Now()), newBatch("third", time.Now())
+
+	s.enqueue(&delivery{batch: first, spool: noopSpool{}, pending: 1})
+	s.enqueue(&delivery{batch: second, spool: noopSpool{}, pending: 1})
+	assert.Equal(t, []string{first.ID}, queuedBatchIDs(s))
+	s.enqueue(&delivery{batch: third, spool: noopSpool{}, pending: 1})
+	assert.Empty(t, queuedBatchIDs(s), "new batches should be spilled while there are spilled batches, to keep them in order")
+	assert.Equal(t, before.Spilled+2, Stats()["spilling"].Spilled)
+
+	s.spill.feed(s)
+	d := <-s.inChan
+	assert.Equal(t, second.ID, d.batch.ID)
+	assert.Equal(t, "second", d.batch.Body)
+
+	d.done(true)
+	pending, err := s.spill.spool.Pending()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{third.ID}, batchIDs(pending), "delivered batches should be removed from the spill spool")
+
+	s.spill.feed(s)
+	assert.Equal(t, []string{third.ID}, queuedBatchIDs(s))
+	assert.Equal(t, 0, s.spill.backlog)
+	s.spill.feed(s)
+	assert.Empty(t, queuedBatchIDs(s), "batches that were fed already shouldn't be fed again")
+}
+
+func Test_DropByLevel(t *testing.T) {
+	before := DroppedEvents()
+	eventlist := []string{
+		`{"level":"debug","msg":"first"}`,
+		`{"level":"ERROR","msg":"second"}`,
+		`{"MESSAGE":"third"}`,
+		`{"level": "Trace","msg":"fourth"}`,
+	}
+
+	kept := dropByLevel(eventlist, parseLevels("trace, debug"))
+
+	assert.Equal(t, []string{`{"level":"ERROR","msg":"second"}`, `{"MESSAGE":"third"}`}, kept)
+	assert.Equal(t, before+2, DroppedEvents())
+}
+
+func Test_ValidateBackpressure(t *testing.T) {
+	assert.NoError(t, ValidateBackpressure("drop-by-level"))
+	assert.Error(t, ValidateBackpressure("drop-all"))
+}
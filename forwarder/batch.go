@@ -0,0 +1,59 @@
+package forwarder
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// Batch is a group of events that is delivered as a single object
+type Batch struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	// Time is the timestamp of the earliest event in the batch
+	Time time.Time `json:"time"`
+	Body string    `json:"body"`
+	// Host, Stream and Seq let the readers restore the order of the batches and detect the missing ones.
+	// Stream identifies a run of the log-collector on the host, and Seq numbers the batches of the stream starting from 1.
+	Host   string `json:"host,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+	// Cursor is the journald cursor of the last event in the batch
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func newBatch(body string, t time.Time) *Batch {
+	// the unix nano prefix keeps the spool files sorted in creation order
+	now := time.Now()
+	return &Batch{ID: fmt.Sprintf("%019d_%v", now.UnixNano(), uuid.New()), Created: now, Time: t, Body: body}
+}
+
+// sequence numbers the batches of a stream
+type sequence struct {
+	host   string
+	stream string
+	last   uint64
+}
+
+func newSequence(host string) *sequence {
+	// the streams of a host sort in the order they were started
+	return &sequence{host: host, stream: fmt.Sprintf("%019d", time.Now().UnixNano())}
+}
+
+func (s *sequence) assign(b *Batch) {
+	b.Host = s.host
+	b.Stream = s.stream
+	b.Seq = atomic.AddUint64(&s.last, 1)
+}
+
+// hostname is the -host value, falling back to the hostname reported by the kernel
+func hostname() string {
+	if Host != "" {
+		return Host
+	}
+	h, _ := os.Hostname()
+	return h
+}
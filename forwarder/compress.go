@@ -0,0 +1,60 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// compression describes how the S3 objects are encoded
+type compression struct {
+	// encoding is the Content-Encoding of the objects, empty if they are not compressed
+	encoding string
+	// suffix is appended to the object keys, so that the readers can recognise the format
+	suffix    string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+func newCompression(name string) (*compression, error) {
+	switch name {
+	case "", compressionNone:
+		return &compression{}, nil
+	case compressionGzip:
+		return &compression{"gzip", ".gz", func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}}, nil
+	case compressionZstd:
+		return &compression{"zstd", ".zst", func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q, expected one of %v, %v or %v", name, compressionNone, compressionGzip, compressionZstd)
+}
+
+func (c *compression) compress(obj string) ([]byte, error) {
+	if c.newWriter == nil {
+		return []byte(obj), nil
+	}
+	var buf bytes.Buffer
+	w, err := c.newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, obj); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Compression_None(t *testing.T) {
+	c, err := newCompression(compressionNone)
+	assert.NoError(t, err)
+
+	body, err := c.compress(event)
+	assert.NoError(t, err)
+	assert.Equal(t, event, string(body))
+	assert.Empty(t, c.encoding)
+	assert.Empty(t, c.suffix)
+}
+
+func Test_Compression_Gzip(t *testing.T) {
+	c, err := newCompression(compressionGzip)
+	assert.NoError(t, err)
+
+	body, err := c.compress(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "gzip", c.encoding)
+	assert.Equal(t, ".gz", c.suffix)
+
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	assert.NoError(t, err)
+	actual, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, event, string(actual))
+}
+
+func Test_Compression_Zstd(t *testing.T) {
+	c, err := newCompression(compressionZstd)
+	assert.NoError(t, err)
+
+	body, err := c.compress(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "zstd", c.encoding)
+	assert.Equal(t, ".zst", c.suffix)
+
+	r, err := zstd.NewReader(bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer r.Close()
+	actual, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, event, string(actual))
+}
+
+func Test_Compression_Unknown(t *testing.T) {
+	_, err := newCompression("lz4")
+	assert.Error(t, err)
+}
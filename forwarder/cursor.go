@@ -0,0 +1,137 @@
+package forwarder
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Financial-Times/log-collector/metrics"
+)
+
+var (
+	// CursorFile is where the journald cursor of the last delivered event is kept, for journalctl --after-cursor to resume from
+	CursorFile  string
+	cursorRegex = regexp.MustCompile(`"__CURSOR":"([^"\\]*)",?`)
+)
+
+// extractCursor returns the journald cursor of the event, and the event without it
+func extractCursor(e string) (string, string) {
+	loc := cursorRegex.FindStringSubmatchIndex(e)
+	if loc == nil {
+		return "", e
+	}
+	cursor := e[loc[2]:loc[3]]
+	start, end := loc[0], loc[1]
+	// the cursor is the last property, the comma to remove is the one before it
+	if e[end-1] != ',' && start > 0 && e[start-1] == ',' {
+		start--
+	}
+	return cursor, e[:start] + e[end:]
+}
+
+// maxAckedAhead bounds the batches acknowledged ahead of a batch that never was, e.g. one lost on its way. Past it, the cursor
+// moves on without the missing batch rather than holding the acknowledged ones in memory for good.
+const maxAckedAhead = 10000
+
+// checkpoint records how far the delivery of the events got
+type checkpoint interface {
+	// Ack is called once every sink is done with the batch, whether it was delivered or failed for good and kept by the spool
+	Ack(b *Batch)
+}
+
+func newCheckpoint(path string, stream string) checkpoint {
+	if path == "" {
+		return noopCheckpoint{}
+	}
+	return &cursorFile{path: path, stream: stream, next: 1, acked: make(map[uint64]string)}
+}
+
+// cursorFile keeps the cursor of the delivered events in a file. Batches are acknowledged out of order by the parallel workers,
+// so the cursor only moves past a batch once every earlier batch of the stream was acknowledged too. Resuming from the cursor
+// never skips an undelivered event, although the events of the batches acknowledged out of order may be delivered twice.
+// The batches that failed for good are acknowledged too when the spool keeps them, since it replays them on restart.
+type cursorFile struct {
+	sync.Mutex
+	path   string
+	stream string
+	next   uint64            //sequence number of the first batch not acknowledged yet
+	acked  map[uint64]string //cursors of the batches acknowledged ahead of next
+}
+
+func (c *cursorFile) Ack(b *Batch) {
+	c.Lock()
+	defer c.Unlock()
+	// batches of previous runs are replayed from the spool, their events are read from journald again anyway
+	if b.Stream != c.stream || b.Seq < c.next {
+		return
+	}
+	c.acked[b.Seq] = b.Cursor
+	cursor := c.advance()
+	if len(c.acked) > maxAckedAhead {
+		var first uint64
+		for seq := range c.acked {
+			if first == 0 || seq < first {
+				first = seq
+			}
+		}
+		log.Printf("Batches %v to %v were never acknowledged, moving the cursor past them, their events may be lost\n", c.next, first-1)
+		metrics.CheckpointSkipped.Add(float64(first - c.next))
+		c.next = first
+		if skipped := c.advance(); skipped != "" {
+			cursor = skipped
+		}
+	}
+	metrics.CheckpointBlocked.Set(float64(len(c.acked)))
+	if cursor == "" {
+		return
+	}
+	if err := writeFileAtomically(c.path, []byte(cursor+"\n")); err != nil {
+		log.Printf("Failed to write cursor of batch %v to %v: %v\n", b.ID, c.path, err)
+	}
+}
+
+// advance moves next past the acknowledged batches, and returns the last cursor found in them
+func (c *cursorFile) advance() string {
+	cursor := ""
+	for {
+		acked, found := c.acked[c.next]
+		if !found {
+			return cursor
+		}
+		delete(c.acked, c.next)
+		c.next++
+		if acked != "" {
+			cursor = acked
+		}
+	}
+}
+
+// ReadCursor returns the cursor kept in the file, or an empty string if there is none yet
+func ReadCursor(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeFileAtomically writes to a temporary file first, so that a crash never leaves a partially written file behind
+func writeFileAtomically(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// noopCheckpoint is used when checkpointing is disabled
+type noopCheckpoint struct{}
+
+func (noopCheckpoint) Ack(*Batch) {}
@@ -0,0 +1,173 @@
+package forwarder
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExtractCursor(t *testing.T) {
+	testCases := []struct {
+		event    string
+		cursor   string
+		stripped string
+	}{
+		{`{"MESSAGE":"hello","__CURSOR":"s=1;i=2","platform":"up-k8s"}`, "s=1;i=2", `{"MESSAGE":"hello","platform":"up-k8s"}`},
+		{`{"MESSAGE":"hello","__CURSOR":"s=1;i=2"}`, "s=1;i=2", `{"MESSAGE":"hello"}`},
+		{`{"__CURSOR":"s=1;i=2"}`, "s=1;i=2", `{}`},
+		{`{"MESSAGE":"hello"}`, "", `{"MESSAGE":"hello"}`},
+	}
+	for _, c := range testCases {
+		cursor, stripped := extractCursor(c.event)
+		assert.Equal(t, c.cursor, cursor, c.event)
+		assert.Equal(t, c.stripped, stripped, c.event)
+	}
+}
+
+func Test_CursorFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cursor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cursor")
+
+	cursor, err := ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Empty(t, cursor)
+
+	c := newCheckpoint(path, "stream")
+	c.Ack(&Batch{Stream: "stream", Seq: 2, Cursor: "s=1;i=2"})
+	cursor, err = ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Empty(t, cursor, "the cursor shouldn't move past the batches that weren't acknowledged yet")
+
+	c.Ack(&Batch{Stream: "previous", Seq: 1, Cursor: "s=0;i=9"})
+	cursor, err = ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Empty(t, cursor, "the batches of previous runs shouldn't move the cursor")
+
+	c.Ack(&Batch{Stream: "stream", Seq: 1, Cursor: "s=1;i=1"})
+	cursor, err = ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "s=1;i=2", cursor, "the cursor should move past every acknowledged batch")
+
+	c.Ack(&Batch{Stream: "stream", Seq: 3})
+	cursor, err = ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "s=1;i=2", cursor, "batches without a cursor shouldn't reset it")
+
+	c.Ack(&Batch{Stream: "stream", Seq: 4, Cursor: "s=1;i=4"})
+	cursor, err = ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "s=1;i=4", cursor)
+}
+
+func Test_ForwarderCheckpointsDeliveredCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cursor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(cursorFile string) { CursorFile = cursorFile }(CursorFile)
+	CursorFile = filepath.Join(dir, "cursor")
+	defer useS3Mock()()
+
+	in, out := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		Forward(in)
+		wg.Done()
+	}()
+
+	for i := 0; i < Batchsize; i++ {
+		out.Write([]byte(fmt.Sprintf(`{"MESSAGE":"message %v","__CURSOR":"s=1;i=%v","platform":"up-k8s"}`+"\n", i, i)))
+	}
+
+	if err := out.Close(); err != nil {
+		assert.Fail(t, "Error closing the pipe writer %v", err)
+	}
+
+	if waitTimeout(&wg, 2*time.Second) {
+		assert.Fail(t, "Forwarder should have been stopped on pipe close")
+	}
+
+	cursor, err := ReadCursor(CursorFile)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("s=1;i=%v", Batchsize-1), cursor)
+	s3Mock.RLock()
+	defer s3Mock.RUnlock()
+	assert.Len(t, s3Mock.cache, 1)
+	assert.NotContains(t, s3Mock.cache[0], "__CURSOR", "the cursor shouldn't be forwarded")
+}
+
+func Test_CursorMovesPastFailedDeliveries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cursor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cursor")
+	sp, err := newSpool(filepath.Join(dir, "spool"))
+	assert.NoError(t, err)
+
+	sink := newSinkDispatch("failing", &failingS3ServiceMock{}, nil, retryPolicy{})
+	defer func() { sink.stats.LastFailed = 0 }()
+	sink.workers = 1
+	d := &dispatch{sinks: []*sinkDispatch{sink}, spool: sp, checkpoint: newCheckpoint(path, "stream")}
+	d.Start()
+	for i := 1; i <= 3; i++ {
+		d.Enqueue(&Batch{ID: fmt.Sprintf("batch-%v", i), Stream: "stream", Seq: uint64(i), Cursor: fmt.Sprintf("s=1;i=%v", i), Created: time.Now()})
+	}
+	d.Stop()
+
+	cursor, err := ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "s=1;i=3", cursor, "the failed batches should not hold the cursor back")
+	pending, err := sp.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 3, "the failed batches should stay spooled to be replayed")
+}
+
+func Test_CursorHoldsBackFailedDeliveriesWithoutSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cursor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cursor")
+
+	sink := newSinkDispatch("failing", &failingS3ServiceMock{}, nil, retryPolicy{})
+	defer func() { sink.stats.LastFailed = 0 }()
+	sink.workers = 1
+	d := &dispatch{sinks: []*sinkDispatch{sink}, spool: noopSpool{}, checkpoint: newCheckpoint(path, "stream")}
+	d.Start()
+	d.Enqueue(&Batch{ID: "batch-1", Stream: "stream", Seq: 1, Cursor: "s=1;i=1", Created: time.Now()})
+	d.Stop()
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the cursor should not move past a failed batch that no spool replays")
+}
+
+func Test_CursorSkipsBatchesNeverAcknowledged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cursor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cursor")
+
+	c := newCheckpoint(path, "stream").(*cursorFile)
+	skipped := testutil.ToFloat64(metrics.CheckpointSkipped)
+	// batch 1 is never acknowledged
+	for seq := uint64(2); seq <= maxAckedAhead+2; seq++ {
+		c.Ack(&Batch{Stream: "stream", Seq: seq, Cursor: fmt.Sprintf("s=1;i=%v", seq)})
+	}
+	assert.Empty(t, c.acked, "the acknowledged batches shouldn't be kept once the cursor moved past them")
+	cursor, err := ReadCursor(path)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("s=1;i=%v", maxAckedAhead+2), cursor)
+	assert.Equal(t, skipped+1, testutil.ToFloat64(metrics.CheckpointSkipped), "the skipped batch should be counted")
+}
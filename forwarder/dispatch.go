@@ -3,47 +3,290 @@ package forwarder
 import (
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
 )
 
 type Dispatch interface {
 	Start()
 	Stop()
-	Enqueue(s string)
+	Enqueue(b *Batch)
+	// Saturated reports whether the queue of any sink is full
+	Saturated() bool
 }
 
+// dispatch fans the batches out to every sink. Each sink has its own queue and workers, so a slow sink doesn't hold back the others.
 type dispatch struct {
-	cache  S3Service
-	inChan chan string
-	wg     sync.WaitGroup
+	sinks      []*sinkDispatch
+	spool      spool
+	checkpoint checkpoint
+}
+
+// sinkDispatch delivers the batches to a single sink
+type sinkDispatch struct {
+	name       string
+	sink       Sink
+	deadLetter Sink
+	retry      retryPolicy
+	workers    int
+	// backpressure is the policy applied when the queue is full
+	backpressure string
+	spill        *spiller
+	// checkpoint acknowledges the batches fed back from the spill spool
+	checkpoint checkpoint
+	stats      *DeliveryStats
+	inChan     chan *delivery
+	quit       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// delivery is a batch on its way to the sinks. It stays in the spool until every sink is done with it.
+type delivery struct {
+	batch      *Batch
+	spool      spool
+	checkpoint checkpoint
+	pending    int32
+	failed     int32
+	handedOver int32
 }
 
 func NewDispatch(bucketName string, awsRegion string, prefix string) Dispatch {
-	svc, _ := NewS3Service(bucketName, awsRegion, prefix)
-	return &dispatch{svc, nil, sync.WaitGroup{}}
+	names, err := ParseSinks(Sinks)
+	if err != nil {
+		log.Fatalf("Invalid sinks: %v", err)
+	}
+	sp, err := newSpool(SpoolDir)
+	if err != nil {
+		log.Fatalf("Failed to create spool directory %v: %v", SpoolDir, err)
+	}
+	deadLetter := newDeadLetter(awsRegion, prefix)
+	stream := ""
+	if batchSequence != nil {
+		stream = batchSequence.stream
+	}
+	logDispatch := &dispatch{spool: sp, checkpoint: newCheckpoint(CursorFile, stream)}
+	for _, name := range names {
+		sink, err := newSink(name, bucketName, awsRegion, prefix)
+		if err != nil {
+			log.Fatalf("Failed to create %v sink: %v", name, err)
+		}
+		s := newSinkDispatch(name, sink, deadLetter, newRetryPolicy())
+		if Ordered {
+			// parallel workers would deliver the batches out of order
+			s.workers = 1
+		}
+		s.checkpoint = logDispatch.checkpoint
+		if Backpressure == backpressureSpill {
+			if s.spill, err = newSpiller(name); err != nil {
+				log.Fatalf("Failed to create spill directory of %v sink: %v", name, err)
+			}
+		}
+		logDispatch.sinks = append(logDispatch.sinks, s)
+	}
+	return logDispatch
+}
+
+func newSinkDispatch(name string, sink Sink, deadLetter Sink, retry retryPolicy) *sinkDispatch {
+	return &sinkDispatch{name: name, sink: sink, deadLetter: deadLetter, retry: retry, workers: Workers, backpressure: Backpressure, stats: statsFor(name)}
 }
 
 func (logDispatch *dispatch) Start() {
-	logDispatch.inChan = make(chan string, ChanBuffer)
-	for i := 0; i < Workers; i++ {
-		logDispatch.wg.Add(1)
+	for _, s := range logDispatch.sinks {
+		s.start()
+	}
+	logDispatch.replay()
+}
+
+// replay re-enqueues the batches left in the spool by a previous run
+func (logDispatch *dispatch) replay() {
+	pending, err := logDispatch.spool.Pending()
+	if err != nil {
+		log.Printf("Failed to read spooled batches: %v\n", err)
+		return
+	}
+	if len(pending) > 0 {
+		log.Printf("Replaying %v spooled batches\n", len(pending))
+	}
+	for _, b := range pending {
+		// the backpressure policies don't apply to the replayed batches, the input waits for them to be queued
+		d := logDispatch.newDelivery(b)
+		for _, s := range logDispatch.sinks {
+			s.inChan <- d
+		}
+	}
+}
+
+func (logDispatch *dispatch) Stop() {
+	for _, s := range logDispatch.sinks {
+		s.stop()
+	}
+}
+
+func (logDispatch *dispatch) Enqueue(b *Batch) {
+	// persist the batch before attempting the delivery, it will be removed once delivered
+	if err := logDispatch.spool.Write(b); err != nil {
+		log.Printf("Failed to spool batch %v: %v\n", b.ID, err)
+	}
+	logDispatch.fanOut(b)
+}
+
+func (logDispatch *dispatch) fanOut(b *Batch) {
+	d := logDispatch.newDelivery(b)
+	// the sinks with room get the batch right away, and the backpressure policies of the full ones are applied side by side,
+	// so that a full queue holds back the input without keeping the batch from the other sinks
+	var wg sync.WaitGroup
+	for _, s := range logDispatch.sinks {
+		if s.offer(d) {
+			continue
+		}
+		wg.Add(1)
+		go func(s *sinkDispatch) {
+			defer wg.Done()
+			s.enqueue(d)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (logDispatch *dispatch) newDelivery(b *Batch) *delivery {
+	return &delivery{batch: b, spool: logDispatch.spool, checkpoint: logDispatch.checkpoint, pending: int32(len(logDispatch.sinks))}
+}
+
+func (logDispatch *dispatch) Saturated() bool {
+	for _, s := range logDispatch.sinks {
+		if s.saturated() {
+			return true
+		}
+	}
+	return false
+}
+
+// done is called by every sink once it finished with the batch, successfully or not. A failed batch stays in the spool
+// to be replayed on restart, so it doesn't hold the checkpoint back, unless there is no spool to replay it from.
+func (d *delivery) done(ok bool) {
+	if !ok {
+		atomic.StoreInt32(&d.failed, 1)
+	}
+	if atomic.AddInt32(&d.pending, -1) > 0 {
+		return
+	}
+	failed := atomic.LoadInt32(&d.failed) == 1
+	if !failed {
+		if err := d.spool.Remove(d.batch); err != nil {
+			log.Printf("Failed to remove delivered batch %v from spool: %v\n", d.batch.ID, err)
+		}
+	}
+	if _, spooled := d.spool.(*dirSpool); failed && !spooled {
+		// its events are read from journald again on restart
+		return
+	}
+	if atomic.LoadInt32(&d.handedOver) == 0 && d.checkpoint != nil {
+		d.checkpoint.Ack(d.batch)
+	}
+}
+
+// handOver is called by a sink that took the batch over, e.g. by spilling it. The batch is no longer needed in the spool,
+// but it isn't delivered yet.
+func (d *delivery) handOver() {
+	atomic.StoreInt32(&d.handedOver, 1)
+	d.done(true)
+}
+
+func (s *sinkDispatch) start() {
+	s.inChan = make(chan *delivery, ChanBuffer)
+	s.quit = make(chan struct{})
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
 		go func() {
-			defer logDispatch.wg.Done()
-			for msg := range logDispatch.inChan {
-				err := logDispatch.cache.Put(msg)
-				if err != nil {
-					log.Printf("Unexpected error when caching messages: %v\n", err)
-				}
+			defer s.wg.Done()
+			for d := range s.inChan {
+				s.updateQueueDepth()
+				d.done(s.deliver(d.batch))
 			}
 		}()
 	}
+	if s.spill != nil {
+		s.spill.start(s)
+	}
 }
 
-func (logDispatch *dispatch) Stop() {
-	close(logDispatch.inChan)
-	log.Printf("Waiting buffered channel consumer to finish processing messages\n")
-	logDispatch.wg.Wait()
+func (s *sinkDispatch) stop() {
+	close(s.quit)
+	if s.spill != nil {
+		// the spilled batches that weren't fed yet are delivered on restart
+		s.spill.stop()
+	}
+	close(s.inChan)
+	log.Printf("Waiting buffered channel consumer of the %v sink to finish processing messages\n", s.name)
+	s.wg.Wait()
+	log.Printf("Batches delivered to %v: %v, retried: %v, dead lettered: %v, failed: %v, dropped oldest: %v, dropped newest: %v, spilled: %v\n", s.name,
+		atomic.LoadInt64(&s.stats.Delivered), atomic.LoadInt64(&s.stats.Retried), atomic.LoadInt64(&s.stats.DeadLettered), atomic.LoadInt64(&s.stats.Failed),
+		atomic.LoadInt64(&s.stats.DroppedOldest), atomic.LoadInt64(&s.stats.DroppedNewest), atomic.LoadInt64(&s.stats.Spilled))
+}
+
+// deliver puts the batch to the sink, retrying with backoff until it succeeds or the retry budget is exhausted,
+// in which case the batch is dead lettered. It returns false if the batch has to stay in the spool.
+func (s *sinkDispatch) deliver(b *Batch) bool {
+	for attempt := 1; ; attempt++ {
+		err := s.put(b)
+		if err == nil {
+			s.count(&s.stats.Delivered, "delivered")
+			return true
+		}
+
+		delay, ok := s.retry.next(b, attempt+1, err)
+		if !ok {
+			log.Printf("Giving up on delivering batch %v to %v after %v attempts: %v\n", b.ID, s.name, attempt, err)
+			return s.sendToDeadLetter(b)
+		}
+		log.Printf("Unexpected error when delivering messages to %v (attempt %v), retrying batch %v in %v: %v\n", s.name, attempt, b.ID, delay, err)
+		atomic.AddInt64(&s.stats.Retried, 1)
+		select {
+		case <-time.After(delay):
+		case <-s.quit:
+			// shutting down, the batch stays in the spool to be replayed on restart
+			s.count(&s.stats.Failed, "failed")
+			return false
+		}
+	}
+}
+
+func (s *sinkDispatch) sendToDeadLetter(b *Batch) bool {
+	if s.deadLetter == nil {
+		log.Printf("No dead letter destination configured, batch %v stays spooled\n", b.ID)
+		s.count(&s.stats.Failed, "failed")
+		return false
+	}
+	if err := s.deadLetter.Put(b); err != nil {
+		log.Printf("Failed to dead letter batch %v, it stays spooled: %v\n", b.ID, err)
+		s.count(&s.stats.Failed, "failed")
+		return false
+	}
+	s.count(&s.stats.DeadLettered, "dead_lettered")
+	return true
+}
+
+func (s *sinkDispatch) put(b *Batch) error {
+	start := time.Now()
+	err := s.sink.Put(b)
+	metrics.PutDuration.WithLabelValues(s.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.PutErrors.WithLabelValues(s.name).Inc()
+		atomic.StoreInt64(&s.stats.LastFailed, time.Now().UnixNano())
+	} else {
+		atomic.StoreInt64(&s.stats.LastDelivered, time.Now().UnixNano())
+	}
+	return err
+}
+
+// count records the outcome of a batch both in the delivery stats and the metrics
+func (s *sinkDispatch) count(counter *int64, outcome string) {
+	atomic.AddInt64(counter, 1)
+	metrics.Deliveries.WithLabelValues(s.name, outcome).Inc()
 }
 
-func (logDispatch *dispatch) Enqueue(s string) {
-	logDispatch.inChan <- s
+func (s *sinkDispatch) updateQueueDepth() {
+	metrics.QueueDepth.WithLabelValues(s.name).Set(float64(len(s.inChan)))
 }
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
 )
 
 var (
@@ -18,112 +21,168 @@ var (
 	ChanBuffer     int
 	Batchsize      int
 	Batchtimer     int
+	BatchBytes     int
 	Bucket         string
 	AwsRegion      string
-	br             *bufio.Reader
-	timerChan      = make(chan bool)
+	Compression    string
+	KeyTemplate    string
+	Host           string
+	SpoolDir       string
+	Sinks          string
+	FileSinkDir    string
+	HECURL         string
+	HECToken       string
+	Ordered        bool
 	timestampRegex = regexp.MustCompile("([0-9]+)-(0[1-9]|1[012])-(0[1-9]|[12][0-9]|3[01])[Tt]([01][0-9]|2[0-3]):([0-5][0-9]):([0-5][0-9]|60)(.[0-9]+)?(([Zz])|([+|-]([01][0-9]|2[0-3]):[0-5][0-9]))")
 	logDispatch    Dispatch
+	batchSequence  *sequence
+	levelsToDrop   map[string]bool
 )
 
 // Forwards the log messages that come from the reader to the configured S3 Bucket
 func Forward(r io.Reader) {
-	log.Printf("Log-collector (Workers %v, Batchsize %v, Batchtimer %v): Started\n", Workers, Batchsize, Batchtimer)
+	log.Printf("Log-collector (Workers %v, Batchsize %v, BatchBytes %v, Batchtimer %v): Started\n", Workers, Batchsize, BatchBytes, Batchtimer)
 	defer log.Printf("Log-collector: Stopped\n")
 
-	if br == nil {
-		br = bufio.NewReader(r)
-	}
-	i := 0
-	eventlist := make([]string, Batchsize) //create eventlist slice that is size of -Batchsize
-	timerd := time.Duration(Batchtimer) * time.Second
-	timer := time.NewTimer(timerd) //create timer object with duration specified by -Batchtimer
-	go func() {                    //Create go routine for timer that writes into timerChan when it expires
-		for {
-			<-timer.C
-			timerChan <- true
-		}
-	}()
+	lines := readLines(r)
+	b := newBatcher()
+	batchSequence = newSequence(hostname())
+	levelsToDrop = parseLevels(DropLevels)
 
 	logDispatch = NewDispatch(Bucket, AwsRegion, Env)
 	logDispatch.Start()
+	activeDispatch.Store(logDispatch)
 	defer log.Println("Forwarder completed")
 
 	for {
-		//1. Check whether timer has expired or Batchsize exceeded before processing new string
-		select { //set i equal to Batchsize to trigger delivery if timer expires prior to Batchsize limit is exceeded
-		case <-timerChan:
-			log.Println("Timer expired. Trigger delivery to S3")
-			eventlist = stripEmptyStrings(eventlist) //remove empty values from slice before writing to channel
-			i = Batchsize
-		default:
-			break
-		}
-		if i >= Batchsize { //Trigger delivery if Batchsize is exceeded
-			processAndEnqueue(eventlist)
-			i = 0 //reset i once Batchsize is reached
-			eventlist = nil
-			eventlist = make([]string, Batchsize)
-			timer.Reset(timerd) //Reset timer after message delivery
-		}
-		//2. Process new string after ensuring eventlist has sufficient space
-		str, err := br.ReadString('\n')
-		if err != nil {
-			if err == io.EOF { //Shutdown procedures: process eventlist, close Workers
-				eventlist = stripEmptyStrings(eventlist) //remove empty values from slice before writing to channel
-				if len(eventlist) > 0 {
-					log.Printf("Processing %v batched messages before exit", len(eventlist))
-					processAndEnqueue(eventlist)
+		select {
+		case str, ok := <-lines:
+			if !ok { //Shutdown procedures: process eventlist, close Workers
+				if len(b.eventlist) > 0 {
+					log.Printf("Processing %v batched messages before exit", len(b.eventlist))
+					b.flush()
 				}
 				logDispatch.Stop()
 				return
 			}
-			log.Fatal(err)
-		}
-
-		//3. Append event on eventlist
-		if i != Batchsize {
-			eventlist[i] = str
-			i++
+			b.add(str)
+		case <-b.timerC:
+			log.Println("Timer expired. Trigger delivery to S3")
+			b.flush()
 		}
 	}
 }
 
-func stripEmptyStrings(eventlist []string) []string {
-	//Find empty values in slice. Using map remove empties and return a slice without empty values
-	i := 0
-	map1 := make(map[int]string)
-	for _, v := range eventlist {
-		if v != "" {
-			map1[i] = v
-			i++
+// readLines reads the log messages in the background, so that the batch timer doesn't depend on new input
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		br := bufio.NewReader(r)
+		for {
+			str, err := br.ReadString('\n')
+			if len(str) > 0 {
+				lines <- str
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
+	}()
+	return lines
+}
+
+// batcher groups the events until either Batchsize or BatchBytes is reached, or Batchtimer expires.
+// The timer starts with the first event of the batch, so Batchtimer is an upper bound of the time an event waits to be delivered.
+type batcher struct {
+	eventlist []string
+	cursor    string //journald cursor of the last event in eventlist
+	size      int    //accumulated size of the events in eventlist once encoded, in bytes
+	timer     *time.Timer
+	timerC    <-chan time.Time
+}
+
+func newBatcher() *batcher {
+	return &batcher{eventlist: make([]string, 0, Batchsize)}
+}
+
+func (b *batcher) add(str string) {
+	cursor, str := extractCursor(str)
+	size := encodedSize(str, len(b.eventlist))
+	//Deliver the batched events first if the new event would make the batch exceed BatchBytes
+	if BatchBytes > 0 && len(b.eventlist) > 0 && b.size+size > BatchBytes {
+		b.flush()
+		size = encodedSize(str, 0)
+	}
+	if len(b.eventlist) == 0 {
+		b.timer = time.NewTimer(time.Duration(Batchtimer) * time.Second)
+		b.timerC = b.timer.C
+	}
+	b.eventlist = append(b.eventlist, str)
+	b.size += size
+	if cursor != "" {
+		b.cursor = cursor
 	}
-	mapToSlice := make([]string, len(map1))
-	i = 0
-	for _, v := range map1 {
-		mapToSlice[i] = v
-		i++
+	if len(b.eventlist) >= Batchsize || (BatchBytes > 0 && b.size >= BatchBytes) {
+		b.flush()
+	}
+}
+
+func (b *batcher) flush() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+		b.timerC = nil
 	}
-	return mapToSlice
+	processAndEnqueue(b.eventlist, b.cursor)
+	b.eventlist = make([]string, 0, Batchsize)
+	b.size = 0
+	b.cursor = ""
 }
 
 func writeJSON(eventlist []string) string {
+	return writeHECEvents(eventlist, nil)
+}
+
+// writeSequencedJSON adds the position of every event to its indexed fields, so that the order of the events can be restored
+func writeSequencedJSON(eventlist []string, b *Batch) string {
+	return writeHECEvents(eventlist, func(i int) map[string]interface{} {
+		return sequenceFields(b, i)
+	})
+}
+
+func sequenceFields(b *Batch, i int) map[string]interface{} {
+	return map[string]interface{}{"batch_host": b.Host, "batch_stream": b.Stream, "batch_seq": b.Seq, "batch_index": i}
+}
+
+// widestEpochMillis is the widest HEC time until the year 2286
+const widestEpochMillis = 9999999999.999
+
+// encodedSize is the room the event takes in the batch body once wrapped in a HEC event, which escapes it. The time, and
+// the position fields of the ordered batches, are counted at their widest, so that the encoded batches stay within BatchBytes.
+func encodedSize(e string, i int) int {
+	item := map[string]interface{}{"event": e, "time": widestEpochMillis}
+	if Ordered {
+		item["fields"] = sequenceFields(&Batch{Host: batchSequence.host, Stream: batchSequence.stream, Seq: math.MaxUint64}, i)
+	}
+	jsonItem, err := json.Marshal(&item)
+	if err != nil {
+		return len(` { "event":}`) + len(e)
+	}
+	// and the space separating the events
+	return len(jsonItem) + 1
+}
+
+func writeHECEvents(eventlist []string, fields func(i int) map[string]interface{}) string {
 	//Function produces Splunk HEC compatible json document for batched events
 	// Example: { "event": "event 1"} { "event": "event 2"}
 	var jsonDoc string
 
-	for _, e := range eventlist {
-		timestamp := timestampRegex.FindStringSubmatch(e)
-
-		var err error
-		var t = time.Now()
-		if len(timestamp) > 0 {
-			t, err = time.Parse(time.RFC3339Nano, timestamp[0])
-			if err != nil {
-				t = time.Now()
-			}
-		}
+	for i, e := range eventlist {
+		t := eventTime(e)
 
 		// For Splunk HEC, the default time format is epoch time format, in the format <sec>.<ms>.
 		// For example, 1433188255.500 indicates 1433188255 seconds and 500 milliseconds after epoch, or Monday, June 1, 2015, at 7:50:55 PM GMT.
@@ -132,6 +191,9 @@ func writeJSON(eventlist []string) string {
 			epochMillis = float64(t.UnixNano()) / float64(time.Second)
 		}
 		item := map[string]interface{}{"event": e, "time": epochMillis}
+		if fields != nil {
+			item["fields"] = fields(i)
+		}
 		jsonItem, err := json.Marshal(&item)
 		if err != nil {
 			jsonDoc = strings.Join([]string{jsonDoc, strings.Join([]string{"{ \"event\":", e, "}"}, "")}, " ")
@@ -142,9 +204,46 @@ func writeJSON(eventlist []string) string {
 	return jsonDoc
 }
 
-func processAndEnqueue(eventlist []string) {
+// eventTime returns the first timestamp found in the event, or the current time if there is none
+func eventTime(e string) time.Time {
+	timestamp := timestampRegex.FindStringSubmatch(e)
+	if len(timestamp) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, timestamp[0])
+		if err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// earliestEventTime is used for partitioning the batches by time
+func earliestEventTime(eventlist []string) time.Time {
+	var earliest time.Time
+	for _, e := range eventlist {
+		t := eventTime(e)
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+func processAndEnqueue(eventlist []string, cursor string) {
+	if Backpressure == backpressureDropByLevel && logDispatch.Saturated() {
+		eventlist = dropByLevel(eventlist, levelsToDrop)
+	}
 	if len(eventlist) > 0 { //only attempt delivery if eventlist contains elements
-		jsonSTRING := writeJSON(eventlist)
-		logDispatch.Enqueue(jsonSTRING)
+		b := newBatch("", earliestEventTime(eventlist))
+		b.Cursor = cursor
+		batchSequence.assign(b)
+		if Ordered {
+			b.Body = writeSequencedJSON(eventlist, b)
+		} else {
+			b.Body = writeJSON(eventlist)
+		}
+		metrics.BatchesBuilt.Inc()
+		metrics.BatchEvents.Observe(float64(len(eventlist)))
+		metrics.BatchBytes.Observe(float64(len(b.Body)))
+		logDispatch.Enqueue(b)
 	}
 }
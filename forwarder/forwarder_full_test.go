@@ -1,7 +1,9 @@
 package forwarder
 
 import (
+	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -17,8 +19,8 @@ type s3ServiceMock struct {
 
 var s3Mock = &s3ServiceMock{}
 
-func (s3 *s3ServiceMock) Put(obj string) error {
-	obj = strings.Replace(obj, "dispatch", "safe", -1)
+func (s3 *s3ServiceMock) Put(b *Batch) error {
+	obj := strings.Replace(b.Body, "dispatch", "safe", -1)
 	obj = strings.Replace(obj, "error", "dispatch", -1)
 	s3.Lock()
 	s3.cache = append(s3.cache, obj)
@@ -26,6 +28,17 @@ func (s3 *s3ServiceMock) Put(obj string) error {
 	return nil
 }
 
+// useS3Mock empties the objects of the S3 mock, and returns the function emptying them again once the test is done
+func useS3Mock() func() {
+	reset := func() {
+		s3Mock.Lock()
+		s3Mock.cache = nil
+		s3Mock.Unlock()
+	}
+	reset()
+	return reset
+}
+
 func init() {
 	Env = "dummy"
 	Workers = 8
@@ -33,6 +46,7 @@ func init() {
 	Batchsize = 10
 	Batchtimer = 5
 	Bucket = "testbucket"
+	Sinks = "s3"
 
 	NewS3Service = func(string, string, string) (S3Service, error) {
 		return s3Mock, nil
@@ -40,6 +54,7 @@ func init() {
 }
 
 func Test_Forwarder(t *testing.T) {
+	defer useS3Mock()()
 	in, out := io.Pipe()
 
 	var wg sync.WaitGroup
@@ -70,6 +85,83 @@ func Test_Forwarder(t *testing.T) {
 	assert.Equal(t, messageCount/Batchsize, s3Len)
 }
 
+func Test_ForwarderBatchBytes(t *testing.T) {
+	defer func(batchBytes int) { BatchBytes = batchBytes }(BatchBytes)
+	line := `127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] "GET /eom-file/all/e09b49d6-e1fa-11e4-bb7f-00144feab7de HTTP/1.1" 200 53706 919 919` + "\n"
+	BatchBytes = 3 * encodedSize(line, 0)
+	defer useS3Mock()()
+
+	in, out := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		Forward(in)
+		wg.Done()
+	}()
+
+	messageCount := 9
+	for i := 0; i < messageCount; i++ {
+		out.Write([]byte(line))
+	}
+	out.Write([]byte(strings.Repeat("x", 4*len(line)) + "\n"))
+
+	if err := out.Close(); err != nil {
+		assert.Fail(t, "Error closing the pipe writer %v", err)
+	}
+
+	if waitTimeout(&wg, 2*time.Second) {
+		assert.Fail(t, "Forwarder should have been stopped on pipe close")
+	}
+
+	s3Mock.RLock()
+	defer s3Mock.RUnlock()
+	assert.Len(t, s3Mock.cache, 4, "batches of 3 lines and a batch for the line exceeding the limit on its own")
+	// the workers deliver the batches in any order
+	bodies := append([]string(nil), s3Mock.cache...)
+	sort.Slice(bodies, func(i, j int) bool { return len(bodies[i]) < len(bodies[j]) })
+	for _, body := range bodies[:3] {
+		assert.True(t, len(body) <= BatchBytes, "the encoded batch of %v bytes should fit in %v bytes", len(body), BatchBytes)
+		assert.True(t, len(body) > 2*encodedSize(line, 0), "the batch of %v bytes should hold 3 lines", len(body))
+	}
+}
+
+func Test_ForwarderTimerFlushesWithoutNewInput(t *testing.T) {
+	defer func(batchtimer int) { Batchtimer = batchtimer }(Batchtimer)
+	Batchtimer = 1
+	defer useS3Mock()()
+
+	in, out := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		Forward(in)
+		wg.Done()
+	}()
+
+	out.Write([]byte(`127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] "GET /eom-file/all/e09b49d6-e1fa-11e4-bb7f-00144feab7de HTTP/1.1" 200 53706 919 919` + "\n"))
+
+	deadline := time.Now().Add(3 * time.Second)
+	delivered := 0
+	for delivered == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		s3Mock.RLock()
+		delivered = len(s3Mock.cache)
+		s3Mock.RUnlock()
+	}
+	assert.Equal(t, 1, delivered, "the partial batch should have been delivered once the timer expired, without waiting for new input")
+
+	if err := out.Close(); err != nil {
+		assert.Fail(t, "Error closing the pipe writer %v", err)
+	}
+	if waitTimeout(&wg, 2*time.Second) {
+		assert.Fail(t, "Forwarder should have been stopped on pipe close")
+	}
+}
+
 func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 	c := make(chan struct{})
 	go func() {
@@ -83,3 +175,46 @@ func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 		return true // timed out
 	}
 }
+
+func Test_ForwarderOrdered(t *testing.T) {
+	defer func(ordered bool) { Ordered = ordered }(Ordered)
+	Ordered = true
+	defer useS3Mock()()
+
+	in, out := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		Forward(in)
+		wg.Done()
+	}()
+
+	messageCount := 95
+	for i := 0; i < messageCount; i++ {
+		out.Write([]byte(fmt.Sprintf("message %03d\n", i)))
+	}
+
+	if err := out.Close(); err != nil {
+		assert.Fail(t, "Error closing the pipe writer %v", err)
+	}
+
+	if waitTimeout(&wg, 2*time.Second) {
+		assert.Fail(t, "Forwarder should have been stopped on pipe close")
+	}
+
+	s3Mock.RLock()
+	defer s3Mock.RUnlock()
+	assert.Len(t, s3Mock.cache, 10)
+	next := 0
+	for seq, obj := range s3Mock.cache {
+		for index, e := range strings.Split(strings.TrimSpace(obj), "} {") {
+			assert.Contains(t, e, fmt.Sprintf("message %03d", next), "events should be delivered in input order")
+			assert.Contains(t, e, fmt.Sprintf(`"batch_seq":%d`, seq+1))
+			assert.Contains(t, e, fmt.Sprintf(`"batch_index":%d`, index))
+			next++
+		}
+	}
+	assert.Equal(t, messageCount, next)
+}
@@ -0,0 +1,75 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
+)
+
+var (
+	// HealthWindow is how long the input may stay silent, or the sinks keep failing, before the log-collector is unhealthy
+	HealthWindow   time.Duration
+	started        = time.Now()
+	activeDispatch atomic.Value
+)
+
+// CheckInput fails if no log message was read for longer than HealthWindow
+func CheckInput() (string, error) {
+	last := metrics.LastInput()
+	if last.Before(started) {
+		last = started
+	}
+	if silence := time.Since(last); HealthWindow > 0 && silence > HealthWindow {
+		return "", fmt.Errorf("no log message read for %v", silence.Round(time.Second))
+	}
+	return "Log messages are being read", nil
+}
+
+// CheckDelivery fails if a sink kept failing without a successful delivery for longer than HealthWindow
+func CheckDelivery() (string, error) {
+	var failing []string
+	for name, stats := range Stats() {
+		if stats.LastFailed <= stats.LastDelivered {
+			continue
+		}
+		last := time.Unix(0, stats.LastDelivered)
+		if last.Before(started) {
+			last = started
+		}
+		if HealthWindow > 0 && time.Since(last) > HealthWindow {
+			failing = append(failing, fmt.Sprintf("%v (last successful delivery %v)", name, deliveryTime(stats.LastDelivered)))
+		}
+	}
+	if len(failing) > 0 {
+		return "", fmt.Errorf("sinks failing for longer than %v: %v", HealthWindow, strings.Join(failing, ", "))
+	}
+	return "Batches are being delivered", nil
+}
+
+func deliveryTime(t int64) string {
+	if t == 0 {
+		return "never"
+	}
+	return time.Unix(0, t).UTC().Format(time.RFC3339)
+}
+
+// Saturated reports whether the queue of any sink is full, for the inputs that can turn the log messages away
+func Saturated() bool {
+	d, ok := activeDispatch.Load().(Dispatch)
+	return ok && d.Saturated()
+}
+
+// CheckQueues fails if the queue of any sink is full
+func CheckQueues() (string, error) {
+	if _, ok := activeDispatch.Load().(Dispatch); !ok {
+		return "Dispatch not started yet", nil
+	}
+	if Saturated() {
+		return "", errors.New("the queue of a sink is full, the input is held back or batches are dropped by the backpressure policy")
+	}
+	return "Sink queues have room", nil
+}
@@ -0,0 +1,59 @@
+package forwarder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckInput(t *testing.T) {
+	defer func(window time.Duration, s time.Time) { HealthWindow, started = window, s }(HealthWindow, started)
+	HealthWindow = 10 * time.Millisecond
+	started = time.Now().Add(-time.Hour)
+
+	metrics.RecordInput()
+	_, err := CheckInput()
+	assert.NoError(t, err)
+
+	time.Sleep(2 * HealthWindow)
+	_, err = CheckInput()
+	assert.Error(t, err, "the input should be considered stalled")
+}
+
+func Test_CheckDelivery(t *testing.T) {
+	defer func(window time.Duration, s time.Time) { HealthWindow, started = window, s }(HealthWindow, started)
+	defer useSinkStats()()
+	HealthWindow = time.Minute
+	started = time.Now().Add(-time.Hour)
+	stats := statsFor("unhealthy")
+
+	stats.LastDelivered = time.Now().Add(-30 * time.Second).UnixNano()
+	stats.LastFailed = time.Now().UnixNano()
+	_, err := CheckDelivery()
+	assert.NoError(t, err, "failing for less than the health window")
+
+	stats.LastDelivered = time.Now().Add(-2 * time.Minute).UnixNano()
+	_, err = CheckDelivery()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unhealthy")
+
+	stats.LastDelivered = time.Now().UnixNano()
+	_, err = CheckDelivery()
+	assert.NoError(t, err, "delivering again")
+}
+
+// useSinkStats starts the stats of the sinks afresh, so that the sinks of the other tests are left out, and returns the
+// function restoring them
+func useSinkStats() func() {
+	sinkStatsLock.Lock()
+	defer sinkStatsLock.Unlock()
+	previous := sinkStats
+	sinkStats = make(map[string]*DeliveryStats)
+	return func() {
+		sinkStatsLock.Lock()
+		defer sinkStatsLock.Unlock()
+		sinkStats = previous
+	}
+}
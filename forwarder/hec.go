@@ -0,0 +1,126 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const hecEndpoint = "/services/collector"
+
+// HEC status codes, as documented in https://docs.splunk.com/Documentation/Splunk/latest/Data/TroubleshootHTTPEventCollector
+const (
+	hecCodeSuccess             = 0
+	hecCodeInternalServerError = 8
+	hecCodeServerBusy          = 9
+)
+
+type HECService interface {
+	Put(b *Batch) error
+}
+
+type hecService struct {
+	url   string
+	token string
+	hc    *http.Client
+}
+
+var NewHECService = func(hecURL string, token string) (HECService, error) {
+	hc := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          Workers + 1,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   Workers + 1,
+			TLSHandshakeTimeout:   3 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+	return &hecService{strings.TrimSuffix(hecURL, "/") + hecEndpoint, token, hc}, nil
+}
+
+func (h *hecService) Put(b *Batch) error {
+	req, err := http.NewRequest(http.MethodPost, h.url, strings.NewReader(b.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Splunk "+h.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkHECResponse(resp)
+}
+
+// hecResponse is the body of the HEC responses, e.g. {"text":"Server is busy","code":9}
+type hecResponse struct {
+	Text string `json:"text"`
+	Code int    `json:"code"`
+}
+
+func checkHECResponse(resp *http.Response) error {
+	body := hecResponse{Code: -1}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(data, &body); err != nil {
+		body.Text = strings.TrimSpace(string(data))
+	}
+	if resp.StatusCode == http.StatusOK && (body.Code == hecCodeSuccess || body.Code == -1) {
+		return nil
+	}
+	return &hecError{
+		status:     resp.StatusCode,
+		code:       body.Code,
+		text:       body.Text,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// hecError is returned when HEC rejects a batch
+type hecError struct {
+	status     int
+	code       int
+	text       string
+	retryAfter time.Duration
+}
+
+func (e *hecError) Error() string {
+	return fmt.Sprintf("HEC responded with status %v, code %v: %v", e.status, e.code, e.text)
+}
+
+// Temporary tells whether the batch may be accepted later: when HEC is throttling or overloaded.
+// Otherwise the batch or the configuration is invalid, so it is pointless to retry.
+func (e *hecError) Temporary() bool {
+	if e.status == http.StatusTooManyRequests || e.status >= http.StatusInternalServerError {
+		return true
+	}
+	return e.code == hecCodeInternalServerError || e.code == hecCodeServerBusy
+}
+
+// RetryAfter is the delay requested by HEC before retrying, if any
+func (e *hecError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
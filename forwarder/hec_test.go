@@ -0,0 +1,91 @@
+package forwarder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HECService_Put(t *testing.T) {
+	var path, auth, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+
+	hec, err := NewHECService(server.URL, "secret-token")
+	assert.NoError(t, err)
+
+	events := writeJSON([]string{event})
+	assert.NoError(t, hec.Put(newBatch(events, time.Now())))
+	assert.Equal(t, "/services/collector", path)
+	assert.Equal(t, "Splunk secret-token", auth)
+	assert.Equal(t, events, body)
+}
+
+func Test_HECService_Errors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		status     int
+		body       string
+		retryAfter string
+		temporary  bool
+		delay      time.Duration
+	}{
+		{
+			name:      "server busy",
+			status:    http.StatusServiceUnavailable,
+			body:      `{"text":"Server is busy","code":9}`,
+			temporary: true,
+		},
+		{
+			name:       "throttled",
+			status:     http.StatusTooManyRequests,
+			body:       `Too Many Requests`,
+			retryAfter: "30",
+			temporary:  true,
+			delay:      30 * time.Second,
+		},
+		{
+			name:      "invalid data format",
+			status:    http.StatusBadRequest,
+			body:      `{"text":"Invalid data format","code":6,"invalid-event-number":0}`,
+			temporary: false,
+		},
+		{
+			name:      "invalid token",
+			status:    http.StatusForbidden,
+			body:      `{"text":"Invalid token","code":4}`,
+			temporary: false,
+		},
+	}
+
+	for _, c := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.retryAfter != "" {
+				w.Header().Set("Retry-After", c.retryAfter)
+			}
+			w.WriteHeader(c.status)
+			w.Write([]byte(c.body))
+		}))
+
+		hec, _ := NewHECService(server.URL, "token")
+		err := hec.Put(newBatch(writeJSON([]string{event}), time.Now()))
+		server.Close()
+
+		if assert.Error(t, err, c.name) {
+			hecErr, ok := err.(*hecError)
+			assert.True(t, ok, c.name)
+			assert.Equal(t, c.temporary, hecErr.Temporary(), c.name)
+			assert.Equal(t, c.delay, hecErr.RetryAfter(), c.name)
+		}
+	}
+}
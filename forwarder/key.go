@@ -0,0 +1,91 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// DefaultKeyTemplate is the layout of the S3 object keys used before the keys became configurable
+const DefaultKeyTemplate = "{{.Env}}/{{.UnixNano}}_{{.UUID}}"
+
+// keyFields are the values available to the S3 key templates.
+// The time based fields are derived from the earliest event of the batch, in UTC, so that they can be used as Hive style partitions, e.g.
+// {{.Env}}/year={{.Year}}/month={{.Month}}/day={{.Day}}/hour={{.Hour}}/host={{.Host}}/{{.UnixNano}}_{{.UUID}}
+// Stream and Seq identify the batch in the sequence of batches of the host, e.g. {{.Stream}}_{{printf "%020d" .Seq}}
+type keyFields struct {
+	Env      string
+	Host     string
+	Year     string
+	Month    string
+	Day      string
+	Hour     string
+	Time     time.Time
+	UnixNano int64
+	BatchID  string
+	Stream   string
+	Seq      uint64
+	UUID     string
+}
+
+type keyTemplate struct {
+	tmpl *template.Template
+	env  string
+	host string
+}
+
+func newKeyTemplate(text string, env string, host string) (*keyTemplate, error) {
+	if text == "" {
+		text = DefaultKeyTemplate
+	}
+	tmpl, err := template.New("key").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	k := &keyTemplate{tmpl, env, host}
+	// fail on startup rather than on every upload when the template refers to unknown fields, and rather than overwriting
+	// the objects of the batches it gives the same key, e.g. the batches of the same hour or those of another run numbered alike
+	now := time.Now()
+	samples := []*Batch{newBatch("", now), newBatch("", now), newBatch("", now)}
+	samples[0].Stream, samples[0].Seq = "1", 1
+	samples[1].Stream, samples[1].Seq = "1", 2
+	samples[2].Stream, samples[2].Seq = "2", 1
+	keys := make(map[string]bool)
+	for _, b := range samples {
+		key, err := k.key(b)
+		if err != nil {
+			return nil, err
+		}
+		if keys[key] {
+			return nil, fmt.Errorf("the key template gives different batches the same key %v, it requires UnixNano, UUID, BatchID or both Stream and Seq", key)
+		}
+		keys[key] = true
+	}
+	return k, nil
+}
+
+func (k *keyTemplate) key(b *Batch) (string, error) {
+	t := b.Time.UTC()
+	fields := keyFields{
+		Env:      k.env,
+		Host:     k.host,
+		Year:     fmt.Sprintf("%04d", t.Year()),
+		Month:    fmt.Sprintf("%02d", t.Month()),
+		Day:      fmt.Sprintf("%02d", t.Day()),
+		Hour:     fmt.Sprintf("%02d", t.Hour()),
+		Time:     t,
+		UnixNano: time.Now().UnixNano(),
+		BatchID:  b.ID,
+		Stream:   b.Stream,
+		Seq:      b.Seq,
+		UUID:     uuid.New(),
+	}
+	var buf bytes.Buffer
+	if err := k.tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,58 @@
+package forwarder
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KeyTemplate_Default(t *testing.T) {
+	k, err := newKeyTemplate("", "upp-prod-delivery-eu", "ip-10-172-32-11")
+	assert.NoError(t, err)
+
+	key, err := k.key(newBatch("event", time.Now()))
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^upp-prod-delivery-eu/\d+_[0-9a-f-]{36}$`), key)
+}
+
+func Test_KeyTemplate_HivePartitions(t *testing.T) {
+	k, err := newKeyTemplate("{{.Env}}/year={{.Year}}/month={{.Month}}/day={{.Day}}/hour={{.Hour}}/host={{.Host}}/{{.BatchID}}", "upp-prod-delivery-eu", "ip-10-172-32-11")
+	assert.NoError(t, err)
+
+	eventTime := time.Date(2018, time.December, 3, 9, 14, 47, 0, time.FixedZone("CET", 3600))
+	b := newBatch("event", eventTime)
+	key, err := k.key(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "upp-prod-delivery-eu/year=2018/month=12/day=03/hour=08/host=ip-10-172-32-11/"+b.ID, key)
+}
+
+func Test_KeyTemplate_Invalid(t *testing.T) {
+	_, err := newKeyTemplate("{{.Env}/{{.UUID}}", "dummy", "host")
+	assert.Error(t, err)
+
+	_, err = newKeyTemplate("{{.Env}}/{{.Unknown}}", "dummy", "host")
+	assert.Error(t, err)
+}
+
+func Test_KeyTemplate_NotUnique(t *testing.T) {
+	for _, text := range []string{"{{.Env}}/{{.Host}}/{{.Hour}}", "{{.Env}}/{{.Seq}}", "{{.Env}}/{{.Stream}}"} {
+		_, err := newKeyTemplate(text, "dummy", "host")
+		if assert.Error(t, err, "%v gives different batches the same key", text) {
+			assert.Contains(t, err.Error(), "the same key")
+		}
+	}
+
+	_, err := newKeyTemplate("{{.Env}}/{{.Stream}}_{{.Seq}}", "dummy", "host")
+	assert.NoError(t, err)
+}
+
+func Test_EarliestEventTime(t *testing.T) {
+	eventlist := []string{
+		`{"@time":"2017-08-18T14:37:15.639583741Z","MESSAGE":"second"}`,
+		`{"@time":"2017-08-18T14:37:14.639583741Z","MESSAGE":"first"}`,
+	}
+	expected, _ := time.Parse(time.RFC3339Nano, "2017-08-18T14:37:14.639583741Z")
+	assert.True(t, expected.Equal(earliestEventTime(eventlist)))
+}
@@ -0,0 +1,124 @@
+package forwarder
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	MaxAttempts      int
+	RetryBackoff     time.Duration
+	MaxRetryBackoff  time.Duration
+	MaxBatchAge      time.Duration
+	DeadLetterDir    string
+	DeadLetterBucket string
+	sinkStats        = make(map[string]*DeliveryStats)
+	sinkStatsLock    sync.Mutex
+)
+
+// DeliveryStats counts the outcome of the batch deliveries to a sink since startup
+type DeliveryStats struct {
+	Delivered    int64
+	Retried      int64
+	DeadLettered int64
+	Failed       int64
+	// batches dropped or spilled by the backpressure policy because the queue was full
+	DroppedOldest int64
+	DroppedNewest int64
+	Spilled       int64
+	// unix nano times of the last successful and failed delivery attempts
+	LastDelivered int64
+	LastFailed    int64
+}
+
+// Stats returns a snapshot of the delivery counters of every sink
+func Stats() map[string]DeliveryStats {
+	sinkStatsLock.Lock()
+	defer sinkStatsLock.Unlock()
+	snapshot := make(map[string]DeliveryStats)
+	for name, stats := range sinkStats {
+		snapshot[name] = DeliveryStats{
+			Delivered:     atomic.LoadInt64(&stats.Delivered),
+			Retried:       atomic.LoadInt64(&stats.Retried),
+			DeadLettered:  atomic.LoadInt64(&stats.DeadLettered),
+			Failed:        atomic.LoadInt64(&stats.Failed),
+			DroppedOldest: atomic.LoadInt64(&stats.DroppedOldest),
+			DroppedNewest: atomic.LoadInt64(&stats.DroppedNewest),
+			Spilled:       atomic.LoadInt64(&stats.Spilled),
+			LastDelivered: atomic.LoadInt64(&stats.LastDelivered),
+			LastFailed:    atomic.LoadInt64(&stats.LastFailed),
+		}
+	}
+	return snapshot
+}
+
+// statsFor returns the counters of the sink, the same ones across restarts of the dispatch
+func statsFor(sink string) *DeliveryStats {
+	sinkStatsLock.Lock()
+	defer sinkStatsLock.Unlock()
+	stats, found := sinkStats[sink]
+	if !found {
+		stats = &DeliveryStats{}
+		sinkStats[sink] = stats
+	}
+	return stats
+}
+
+// retryPolicy decides whether and when a failed delivery is attempted again
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxAge      time.Duration
+}
+
+func newRetryPolicy() retryPolicy {
+	return retryPolicy{MaxAttempts, RetryBackoff, MaxRetryBackoff, MaxBatchAge}
+}
+
+// temporary is implemented by the delivery errors that know whether a retry can succeed
+type temporary interface {
+	Temporary() bool
+}
+
+// retryAfter is implemented by the delivery errors that carry the delay requested by the destination
+type retryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// next returns the delay before the given attempt of delivering the batch after the err failure,
+// or false if the batch has exhausted its retry budget or the failure is permanent
+func (p retryPolicy) next(b *Batch, attempt int, err error) (time.Duration, bool) {
+	if attempt > p.maxAttempts {
+		return 0, false
+	}
+	if t, ok := err.(temporary); ok && !t.Temporary() {
+		return 0, false
+	}
+	delay := p.delay(attempt)
+	if r, ok := err.(retryAfter); ok && r.RetryAfter() > delay {
+		delay = r.RetryAfter()
+	}
+	if p.maxAge > 0 && time.Since(b.Created)+delay > p.maxAge {
+		return 0, false
+	}
+	return delay, true
+}
+
+// delay is an exponential backoff with jitter, so that the workers don't retry in lockstep
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 2; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if p.maxBackoff > 0 && d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,55 @@
+package forwarder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RetryPolicy_ExponentialBackoffWithJitter(t *testing.T) {
+	p := retryPolicy{maxAttempts: 10, backoff: 100 * time.Millisecond, maxBackoff: 400 * time.Millisecond}
+	b := newBatch("event", time.Now())
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 400 * time.Millisecond}
+	for i, max := range expected {
+		delay, ok := p.next(b, i+2, errors.New("S3 is unavailable"))
+		assert.True(t, ok)
+		assert.True(t, delay >= max/2 && delay <= max, "delay %v of attempt %v should be between %v and %v", delay, i+2, max/2, max)
+	}
+}
+
+func Test_RetryPolicy_MaxAttempts(t *testing.T) {
+	p := retryPolicy{maxAttempts: 3, backoff: time.Millisecond, maxBackoff: time.Millisecond}
+	b := newBatch("event", time.Now())
+
+	_, ok := p.next(b, 3, errors.New("S3 is unavailable"))
+	assert.True(t, ok)
+	_, ok = p.next(b, 4, errors.New("S3 is unavailable"))
+	assert.False(t, ok)
+}
+
+func Test_RetryPolicy_MaxAge(t *testing.T) {
+	p := retryPolicy{maxAttempts: 10, backoff: time.Millisecond, maxBackoff: time.Millisecond, maxAge: time.Minute}
+	b := newBatch("event", time.Now())
+
+	_, ok := p.next(b, 2, errors.New("S3 is unavailable"))
+	assert.True(t, ok)
+
+	b.Created = time.Now().Add(-time.Hour)
+	_, ok = p.next(b, 2, errors.New("S3 is unavailable"))
+	assert.False(t, ok)
+}
+
+func Test_RetryPolicy_PermanentErrors(t *testing.T) {
+	p := retryPolicy{maxAttempts: 10, backoff: time.Millisecond, maxBackoff: time.Millisecond}
+	b := newBatch("event", time.Now())
+
+	_, ok := p.next(b, 2, &hecError{status: 400, code: 6, text: "Invalid data format"})
+	assert.False(t, ok)
+
+	delay, ok := p.next(b, 2, &hecError{status: 429, code: -1, retryAfter: time.Minute})
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+}
@@ -1,33 +1,44 @@
 package forwarder
 
 import (
-	"fmt"
+	"bytes"
 	"log"
 	"net"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/pborman/uuid"
 )
 
 type S3Service interface {
-	Put(obj string) error
+	Put(b *Batch) error
 }
 
 type s3Service struct {
-	bucketName string
-	prefix     string
-	svc        *s3.S3
+	bucketName  string
+	keys        *keyTemplate
+	compression *compression
+	svc         *s3.S3
 }
 
 var NewS3Service = func(bucketName string, awsRegion string, prefix string) (S3Service, error) {
 	wrks := Workers
 	spareWorkers := 1
 
+	c, err := newCompression(Compression)
+	if err != nil {
+		log.Fatalf("Invalid S3 compression: %v", err)
+		return nil, err
+	}
+	keys, err := newKeyTemplate(KeyTemplate, prefix, hostname())
+	if err != nil {
+		log.Fatalf("Invalid S3 key template: %v", err)
+		return nil, err
+	}
+
 	hc := &http.Client{
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
@@ -53,14 +64,33 @@ var NewS3Service = func(bucketName string, awsRegion string, prefix string) (S3S
 		return nil, err
 	}
 	svc := s3.New(sess)
-	return &s3Service{bucketName, prefix, svc}, nil
+	return &s3Service{bucketName, keys, c, svc}, nil
 }
 
-func (s *s3Service) Put(obj string) error {
-	uuid := fmt.Sprintf("%v/%v_%v", s.prefix, time.Now().UnixNano(), uuid.New())
-	_, err := s.svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(s.bucketName),
-		Body:   strings.NewReader(obj),
-		Key:    aws.String(uuid)})
+func (s *s3Service) Put(b *Batch) error {
+	key, err := s.keys.key(b)
+	if err != nil {
+		return err
+	}
+	body, err := s.compression.compress(b.Body)
+	if err != nil {
+		return err
+	}
+	// the body is the HEC events separated by spaces, neither a JSON document nor NDJSON
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Body:        bytes.NewReader(body),
+		Key:         aws.String(key + s.compression.suffix),
+		ContentType: aws.String("text/plain"),
+		Metadata: map[string]*string{
+			"host":   aws.String(b.Host),
+			"stream": aws.String(b.Stream),
+			"seq":    aws.String(strconv.FormatUint(b.Seq, 10)),
+		},
+	}
+	if s.compression.encoding != "" {
+		input.ContentEncoding = aws.String(s.compression.encoding)
+	}
+	_, err = s.svc.PutObject(input)
 	return err
 }
@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	sinkS3     = "s3"
+	sinkHEC    = "hec"
+	sinkFile   = "file"
+	sinkStdout = "stdout"
+)
+
+// Sink is a destination the batches are delivered to
+type Sink interface {
+	Put(b *Batch) error
+}
+
+// ParseSinks splits the comma separated list of sink names given by -sinks
+func ParseSinks(names string) ([]string, error) {
+	var sinks []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		switch name {
+		case sinkS3, sinkHEC, sinkFile, sinkStdout:
+		default:
+			return nil, fmt.Errorf("unknown sink %q, expected any of %v, %v, %v or %v", name, sinkS3, sinkHEC, sinkFile, sinkStdout)
+		}
+		seen[name] = true
+		sinks = append(sinks, name)
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("at least one sink is required")
+	}
+	return sinks, nil
+}
+
+func newSink(name string, bucketName string, awsRegion string, prefix string) (Sink, error) {
+	switch name {
+	case sinkS3:
+		return NewS3Service(bucketName, awsRegion, prefix)
+	case sinkHEC:
+		return NewHECService(HECURL, HECToken)
+	case sinkFile:
+		return &fileSink{FileSinkDir}, nil
+	case sinkStdout:
+		return &writerSink{w: os.Stdout}, nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", name)
+}
+
+func newDeadLetter(awsRegion string, prefix string) Sink {
+	if DeadLetterBucket != "" {
+		svc, _ := NewS3Service(DeadLetterBucket, awsRegion, prefix)
+		return svc
+	}
+	if DeadLetterDir != "" {
+		return &fileSink{DeadLetterDir}
+	}
+	return nil
+}
+
+// fileSink stores every batch as a file in a local directory
+type fileSink struct {
+	dir string
+}
+
+func (s *fileSink) Put(b *Batch) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, b.ID+spoolFileSuffix), []byte(b.Body), 0644)
+}
+
+// writerSink writes every batch as a line, e.g. to stdout for debugging
+type writerSink struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (s *writerSink) Put(b *Batch) error {
+	s.Lock()
+	defer s.Unlock()
+	_, err := io.WriteString(s.w, strings.TrimSpace(b.Body)+"\n")
+	return err
+}
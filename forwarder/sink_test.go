@@ -0,0 +1,108 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingSinkMock holds every batch until it is released
+type blockingSinkMock struct {
+	release chan struct{}
+}
+
+func (s *blockingSinkMock) Put(b *Batch) error {
+	<-s.release
+	return nil
+}
+
+func Test_ParseSinks(t *testing.T) {
+	sinks, err := ParseSinks("s3, hec,s3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"s3", "hec"}, sinks)
+
+	_, err = ParseSinks("s3,kafka")
+	assert.Error(t, err)
+
+	_, err = ParseSinks("")
+	assert.Error(t, err)
+}
+
+func Test_Dispatch_SlowSinkDoesNotHoldBackTheOthers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sp, err := newSpool(dir)
+	assert.NoError(t, err)
+
+	fast := &s3ServiceMock{}
+	slow := &blockingSinkMock{make(chan struct{})}
+	d := &dispatch{sinks: []*sinkDispatch{newSinkDispatch("fast", fast, nil, retryPolicy{}), newSinkDispatch("slow", slow, nil, retryPolicy{})}, spool: sp}
+	before := Stats()["fast"]
+	d.Start()
+	d.Enqueue(newBatch("event", time.Now()))
+
+	waitForStats("fast", func(s DeliveryStats) bool { return s.Delivered > before.Delivered }, time.Second)
+	assert.Equal(t, []string{"event"}, fast.cache)
+	pending, err := sp.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1, "the batch should stay spooled until every sink delivered it")
+
+	close(slow.release)
+	d.Stop()
+
+	pending, err = sp.Pending()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func Test_Dispatch_HungSinkDoesNotKeepTheBatchesFromTheOthers(t *testing.T) {
+	defer func(buffer int) { ChanBuffer = buffer }(ChanBuffer)
+	ChanBuffer = 1
+
+	fast := &s3ServiceMock{}
+	hung := &blockingSinkMock{make(chan struct{})}
+	d := &dispatch{sinks: []*sinkDispatch{newSinkDispatch("hung", hung, nil, retryPolicy{}), newSinkDispatch("fast", fast, nil, retryPolicy{})}, spool: noopSpool{}}
+	for _, s := range d.sinks {
+		s.workers = 1
+	}
+	before := Stats()["fast"]
+	d.Start()
+
+	// one batch being delivered, one queued and one waiting for the queue of the hung sink
+	enqueued := make(chan struct{})
+	go func() {
+		defer close(enqueued)
+		for i := 0; i < 3; i++ {
+			d.Enqueue(newBatch(fmt.Sprintf("event-%v", i), time.Now()))
+		}
+	}()
+	waitForStats("fast", func(s DeliveryStats) bool { return s.Delivered >= before.Delivered+3 }, time.Second)
+	fast.RLock()
+	assert.Equal(t, []string{"event-0", "event-1", "event-2"}, fast.cache, "the other sink should get the batches the hung sink has no room for")
+	fast.RUnlock()
+	select {
+	case <-enqueued:
+		assert.Fail(t, "the block policy should hold the input back until the hung sink has room")
+	default:
+	}
+	assert.True(t, d.Saturated())
+
+	close(hung.release)
+	<-enqueued
+	d.Stop()
+}
+
+func Test_WriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := &writerSink{w: &buf}
+
+	assert.NoError(t, s.Put(newBatch(` {"event":"first"} {"event":"second"}`, time.Now())))
+	assert.Equal(t, `{"event":"first"} {"event":"second"}`+"\n", buf.String())
+}
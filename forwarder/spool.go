@@ -0,0 +1,113 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const spoolFileSuffix = ".json"
+
+// spool persists batches until they have been delivered, so that they survive S3 outages and restarts
+type spool interface {
+	Write(b *Batch) error
+	Remove(b *Batch) error
+	Pending() ([]*Batch, error)
+}
+
+func newSpool(dir string) (spool, error) {
+	if dir == "" {
+		return noopSpool{}, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &dirSpool{dir}, nil
+}
+
+// dirSpool keeps every batch as a file in a directory, named after the batch ID
+type dirSpool struct {
+	dir string
+}
+
+func (s *dirSpool) Write(b *Batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path(b), data)
+}
+
+func (s *dirSpool) Remove(b *Batch) error {
+	err := os.Remove(s.path(b))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Pending returns the spooled batches, oldest first
+func (s *dirSpool) Pending() ([]*Batch, error) {
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+
+	var batches []*Batch
+	for _, name := range names {
+		b, err := s.read(name)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			batches = append(batches, b)
+		}
+	}
+	return batches, nil
+}
+
+// names lists the spool files, oldest first
+func (s *dirSpool) names() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), spoolFileSuffix) {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// read returns the batch in the spool file, or nil if the file is corrupted
+func (s *dirSpool) read(name string) (*Batch, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	b := &Batch{}
+	if err := json.Unmarshal(data, b); err != nil {
+		log.Printf("Skipping corrupted spool file %v: %v\n", name, err)
+		return nil, nil
+	}
+	return b, nil
+}
+
+func (s *dirSpool) path(b *Batch) string {
+	return filepath.Join(s.dir, b.ID+spoolFileSuffix)
+}
+
+// noopSpool is used when spooling is disabled
+type noopSpool struct{}
+
+func (noopSpool) Write(*Batch) error         { return nil }
+func (noopSpool) Remove(*Batch) error        { return nil }
+func (noopSpool) Pending() ([]*Batch, error) { return nil, nil }
@@ -0,0 +1,108 @@
+package forwarder
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingS3ServiceMock struct{}
+
+func (s3 *failingS3ServiceMock) Put(b *Batch) error {
+	return errors.New("S3 is unavailable")
+}
+
+func Test_DirSpool_PendingInCreationOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sp, err := newSpool(dir)
+	assert.NoError(t, err)
+
+	first, second := newBatch("first", time.Now()), newBatch("second", time.Now())
+	assert.NoError(t, sp.Write(first))
+	assert.NoError(t, sp.Write(second))
+
+	pending, err := sp.Pending()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.ID, second.ID}, batchIDs(pending))
+
+	assert.NoError(t, sp.Remove(first))
+	pending, err = sp.Pending()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.ID}, batchIDs(pending))
+}
+
+func Test_Dispatch_KeepsFailedBatchesSpooledAndReplaysThem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sp, err := newSpool(dir)
+	assert.NoError(t, err)
+
+	failing := &dispatch{sinks: []*sinkDispatch{newSinkDispatch("failing", &failingS3ServiceMock{}, nil, retryPolicy{})}, spool: sp}
+	failing.Start()
+	failing.Enqueue(newBatch("event", time.Now()))
+	failing.Stop()
+
+	pending, err := sp.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	s3 := &s3ServiceMock{}
+	recovered := &dispatch{sinks: []*sinkDispatch{newSinkDispatch("recovered", s3, nil, retryPolicy{})}, spool: sp}
+	recovered.Start()
+	recovered.Stop()
+
+	assert.Equal(t, []string{"event"}, s3.cache)
+	pending, err = sp.Pending()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func Test_Dispatch_DeadLettersBatchesThatExhaustedTheirRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sp, err := newSpool(dir)
+	assert.NoError(t, err)
+
+	deadLetter := &s3ServiceMock{}
+	retry := retryPolicy{maxAttempts: 3, backoff: time.Millisecond, maxBackoff: 2 * time.Millisecond}
+	d := &dispatch{sinks: []*sinkDispatch{newSinkDispatch("deadlettering", &failingS3ServiceMock{}, deadLetter, retry)}, spool: sp}
+	before := Stats()["deadlettering"]
+	d.Start()
+	d.Enqueue(newBatch("event", time.Now()))
+	waitForStats("deadlettering", func(s DeliveryStats) bool { return s.DeadLettered > before.DeadLettered }, time.Second)
+	d.Stop()
+	after := Stats()["deadlettering"]
+
+	assert.Equal(t, []string{"event"}, deadLetter.cache)
+	assert.Equal(t, int64(2), after.Retried-before.Retried)
+	assert.Equal(t, int64(1), after.DeadLettered-before.DeadLettered)
+	pending, err := sp.Pending()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func waitForStats(sink string, condition func(DeliveryStats) bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for !condition(Stats()[sink]) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func batchIDs(batches []*Batch) []string {
+	var ids []string
+	for _, b := range batches {
+		ids = append(ids, b.ID)
+	}
+	return ids
+}
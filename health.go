@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/service-status-go/gtg"
+
+	"github.com/Financial-Times/log-collector/filter"
+	"github.com/Financial-Times/log-collector/forwarder"
+)
+
+const (
+	systemCode = "log-collector"
+	panicGuide = "https://github.com/Financial-Times/log-collector#health"
+)
+
+func healthChecks() []health.Check {
+	return []health.Check{
+		{
+			ID:               "input",
+			Name:             "Log messages are being read",
+			Severity:         1,
+			BusinessImpact:   "The logs of the node don't reach Splunk",
+			TechnicalSummary: "No log message was read from journalctl for longer than -healthWindow. Check that journalctl is running in the pod.",
+			PanicGuide:       panicGuide,
+			Checker:          forwarder.CheckInput,
+		},
+		{
+			ID:               "delivery",
+			Name:             "Batches are being delivered",
+			Severity:         1,
+			BusinessImpact:   "The logs of the node are delayed or missing in Splunk",
+			TechnicalSummary: "A sink, e.g. S3, kept failing without a successful delivery for longer than -healthWindow. Check the pod logs for the delivery errors.",
+			PanicGuide:       panicGuide,
+			Checker:          forwarder.CheckDelivery,
+		},
+		{
+			ID:               "queues",
+			Name:             "Sink queues have room",
+			Severity:         2,
+			BusinessImpact:   "Logs are delayed, or dropped depending on the backpressure policy",
+			TechnicalSummary: "The queue of a sink is full, because the sink is slower than the log volume of the node.",
+			PanicGuide:       panicGuide,
+			Checker:          forwarder.CheckQueues,
+		},
+		{
+			ID:               "cluster-dns",
+			Name:             "Cluster DNS address is resolvable",
+			Severity:         3,
+			BusinessImpact:   "Monitoring events can't be tagged with the active cluster",
+			TechnicalSummary: "The -dnsAddress of the cluster can't be resolved.",
+			PanicGuide:       panicGuide,
+			Checker:          filter.CheckClusterDNS,
+		},
+	}
+}
+
+func healthHandler() http.HandlerFunc {
+	return health.Handler(health.TimedHealthCheck{
+		HealthCheck: health.HealthCheck{
+			SystemCode:  systemCode,
+			Name:        "Log collector",
+			Description: "Forwards the logs of the node to S3 and Splunk",
+			Checks:      healthChecks(),
+		},
+		Timeout: 10 * time.Second,
+	})
+}
+
+// gtgCheck only fails when no log message was read, an outage of a sink doesn't take the log-collector out of service
+func gtgCheck() gtg.Status {
+	if _, err := forwarder.CheckInput(); err != nil {
+		return gtg.Status{GoodToGo: false, Message: err.Error()}
+	}
+	return gtg.Status{GoodToGo: true}
+}
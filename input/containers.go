@@ -0,0 +1,364 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultContainerLogs are the log files the kubelet links to the logs of every container on the node
+const DefaultContainerLogs = "/var/log/containers/*.log"
+
+var pollInterval = time.Second
+
+// ContainerLogs tails the log files of the Kubernetes containers, written by either the Docker json-file logging driver or a
+// CRI runtime like containerd, and decodes every log line into a message with the same fields as the journald messages of the
+// Docker journald logging driver, e.g. CONTAINER_NAME=k8s_<container>_<pod>_<namespace>.
+// The files found on startup are tailed from their end, the ones created later from their start. Rotated and removed files are
+// read until their end, truncated files, including the ones copied and truncated, from their start again.
+type ContainerLogs struct {
+	pattern  string
+	host     string
+	entries  chan map[string]interface{}
+	quit     chan struct{}
+	tailed   map[string]bool
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+func NewContainerLogs(pattern string, host string) *ContainerLogs {
+	c := &ContainerLogs{
+		pattern: pattern,
+		host:    host,
+		entries: make(chan map[string]interface{}),
+		quit:    make(chan struct{}),
+		tailed:  make(map[string]bool),
+	}
+	c.discover(true)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.discover(false)
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// Decode waits for the next log line of any container. It returns io.EOF once closed.
+func (c *ContainerLogs) Decode(m map[string]interface{}) error {
+	select {
+	case entry := <-c.entries:
+		for k, v := range entry {
+			m[k] = v
+		}
+		return nil
+	case <-c.quit:
+		return io.EOF
+	}
+}
+
+// Close stops tailing the log files
+func (c *ContainerLogs) Close() error {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		c.wg.Wait()
+	})
+	return nil
+}
+
+func (c *ContainerLogs) discover(startup bool) {
+	paths, err := filepath.Glob(c.pattern)
+	if err != nil {
+		log.Printf("Invalid container logs pattern %v: %v\n", c.pattern, err)
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, path := range paths {
+		if c.tailed[path] {
+			continue
+		}
+		name, err := parseContainerLogName(path)
+		if err != nil {
+			continue
+		}
+		// opened right away, so that nothing written after startup is skipped
+		f, info, err := openLog(path, startup)
+		if err != nil {
+			log.Printf("Failed to open container log %v: %v\n", path, err)
+			continue
+		}
+		c.tailed[path] = true
+		c.wg.Add(1)
+		go func(path string) {
+			defer c.wg.Done()
+			c.tail(path, name, f, info)
+			c.lock.Lock()
+			delete(c.tailed, path)
+			c.lock.Unlock()
+		}(path)
+	}
+}
+
+// containerLogName identifies the container from the name of its log file
+type containerLogName struct {
+	pod       string
+	namespace string
+	container string
+	id        string
+}
+
+// parseContainerLogName parses the <pod>_<namespace>_<container>-<container id>.log names of the kubelet
+func parseContainerLogName(path string) (containerLogName, error) {
+	base := strings.TrimSuffix(filepath.Base(path), ".log")
+	i := strings.LastIndex(base, "-")
+	if i < 0 {
+		return containerLogName{}, errors.New("no container id in " + path)
+	}
+	parts := strings.Split(base[:i], "_")
+	if len(parts) != 3 {
+		return containerLogName{}, errors.New("not a <pod>_<namespace>_<container> log file: " + path)
+	}
+	return containerLogName{pod: parts[0], namespace: parts[1], container: parts[2], id: base[i+1:]}, nil
+}
+
+// tail follows the file until it is removed or the input is closed
+func (c *ContainerLogs) tail(path string, name containerLogName, f *os.File, info os.FileInfo) {
+	defer func() { f.Close() }()
+
+	t := &tailedLog{r: bufio.NewReader(f), lines: &lineParser{partial: make(map[string]string)}, head: fingerprint(f)}
+	t.offset, _ = f.Seek(0, io.SeekCurrent)
+	for {
+		if err := c.readLines(t, name); err != nil {
+			log.Printf("Failed to read container log %v: %v\n", path, err)
+			return
+		}
+
+		select {
+		case <-c.quit:
+			return
+		case <-time.After(pollInterval):
+		}
+
+		current, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		removed := err != nil
+		if removed || !os.SameFile(info, current) {
+			// removed or rotated, what was written to the old file since the last poll is read until its end first
+			if err := c.readLines(t, name); err != nil {
+				log.Printf("Failed to read container log %v: %v\n", path, err)
+				return
+			}
+			if removed {
+				// the container is gone and so is its log file
+				return
+			}
+			f.Close()
+			if f, info, err = openLog(path, false); err != nil {
+				log.Printf("Failed to open rotated container log %v: %v\n", path, err)
+				return
+			}
+			t.reset(f)
+			continue
+		}
+		// copytruncate keeps the inode, and the file may have grown back past the offset since the last poll, but its
+		// first line, starting with a timestamp, is a new one
+		head := fingerprint(f)
+		n := len(head)
+		if len(t.head) < n {
+			n = len(t.head)
+		}
+		if current.Size() < t.offset || !bytes.Equal(head[:n], t.head[:n]) {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				log.Printf("Failed to rewind truncated container log %v: %v\n", path, err)
+				return
+			}
+			t.reset(f)
+			continue
+		}
+		t.head = head
+	}
+}
+
+// tailedLog is the read position in a container log file
+type tailedLog struct {
+	r      *bufio.Reader
+	lines  *lineParser
+	offset int64
+	// pending is the start of the line being written
+	pending string
+	// head is the start of the file, telling it apart from the file it was truncated and rewritten into
+	head []byte
+}
+
+func (t *tailedLog) reset(f *os.File) {
+	t.r.Reset(f)
+	t.offset, t.pending, t.head = 0, "", fingerprint(f)
+}
+
+// readLines sends the lines read until the end of the file, the end of the last line is waited for
+func (c *ContainerLogs) readLines(t *tailedLog, name containerLogName) error {
+	for {
+		line, err := t.r.ReadString('\n')
+		t.offset += int64(len(line))
+		if err == io.EOF {
+			t.pending += line
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry, ok := t.lines.parse(t.pending + line); ok {
+			c.send(entry.fields(name, c.host))
+		}
+		t.pending = ""
+	}
+}
+
+// fingerprintSize covers the timestamp of the first line of both the Docker and the CRI log files
+const fingerprintSize = 1024
+
+// fingerprint returns the start of the file, without moving the read position
+func fingerprint(f *os.File) []byte {
+	head := make([]byte, fingerprintSize)
+	n, _ := f.ReadAt(head, 0)
+	return head[:n]
+}
+
+func openLog(path string, fromEnd bool) (*os.File, os.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fromEnd {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return f, info, nil
+}
+
+func (c *ContainerLogs) send(entry map[string]interface{}) {
+	select {
+	case c.entries <- entry:
+	case <-c.quit:
+	}
+}
+
+// containerLogEntry is a whole log line of a container, after joining the partial lines
+type containerLogEntry struct {
+	time    string
+	stream  string
+	message string
+}
+
+func (e containerLogEntry) fields(name containerLogName, host string) map[string]interface{} {
+	m := map[string]interface{}{
+		"MESSAGE":            e.message,
+		"CONTAINER_NAME":     "k8s_" + name.container + "_" + name.pod + "_" + name.namespace,
+		"CONTAINER_ID_FULL":  name.id,
+		"CONTAINER_LOG_TIME": e.time,
+		"NAMESPACE":          name.namespace,
+		"STREAM":             e.stream,
+	}
+	if host != "" {
+		m["_HOSTNAME"] = host
+	}
+	return m
+}
+
+// maxLogLine bounds the size of the lines joined from the split ones, like the syslog messages
+const maxLogLine = maxSyslogMessage
+
+// lineParser parses the lines of a container log file, joining the lines the runtime split
+type lineParser struct {
+	partial map[string]string //the beginning of the split lines, by stream
+}
+
+// parse returns the log entry once the line completes it
+func (p *lineParser) parse(line string) (containerLogEntry, bool) {
+	line = strings.TrimSuffix(line, "\n")
+	if strings.HasPrefix(line, "{") {
+		return p.parseDocker(line)
+	}
+	return p.parseCRI(line)
+}
+
+// parseDocker parses the lines of the json-file logging driver, e.g.
+// {"log":"hello\n","stream":"stdout","time":"2019-01-10T10:00:00.000000000Z"}
+// The lines over 16KB are split into several ones, only the last one ends with a newline.
+func (p *lineParser) parseDocker(line string) (containerLogEntry, bool) {
+	var l struct {
+		Log    string `json:"log"`
+		Stream string `json:"stream"`
+		Time   string `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(line), &l); err != nil {
+		return containerLogEntry{}, false
+	}
+	if !strings.HasSuffix(l.Log, "\n") {
+		return p.split(l.Time, l.Stream, l.Log)
+	}
+	return p.join(l.Time, l.Stream, strings.TrimSuffix(l.Log, "\n")), true
+}
+
+// parseCRI parses the lines of the CRI runtimes, e.g.
+// 2019-01-10T10:00:00.000000000Z stdout F hello
+// where the tag is P for the partial lines and F for the full line or the last part of a split one.
+func (p *lineParser) parseCRI(line string) (containerLogEntry, bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return containerLogEntry{}, false
+	}
+	message := ""
+	if len(parts) == 4 {
+		message = parts[3]
+	}
+	timestamp, stream, tag := parts[0], parts[1], parts[2]
+	if strings.HasPrefix(tag, "P") {
+		return p.split(timestamp, stream, message)
+	}
+	return p.join(timestamp, stream, message), true
+}
+
+// split keeps the part of a split line until the line ends. A line growing past maxLogLine is sent as it is so far, the rest
+// of it following as another entry, rather than being kept in memory for good.
+func (p *lineParser) split(time string, stream string, part string) (containerLogEntry, bool) {
+	p.partial[stream] += part
+	if len(p.partial[stream]) < maxLogLine {
+		return containerLogEntry{}, false
+	}
+	return p.join(time, stream, ""), true
+}
+
+// join returns the entry of the line ending with its last part
+func (p *lineParser) join(time string, stream string, last string) containerLogEntry {
+	message := p.partial[stream] + last
+	delete(p.partial, stream)
+	return containerLogEntry{time: time, stream: stream, message: message}
+}
@@ -0,0 +1,167 @@
+package input
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const containerLogFile = "content-public-read-7b9d6d8c5b-xk4dz_default_content-public-read-0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0.log"
+
+func TestParseContainerLogName(t *testing.T) {
+	name, err := parseContainerLogName("/var/log/containers/" + containerLogFile)
+	assert.NoError(t, err)
+	assert.Equal(t, containerLogName{
+		pod:       "content-public-read-7b9d6d8c5b-xk4dz",
+		namespace: "default",
+		container: "content-public-read",
+		id:        "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0",
+	}, name)
+
+	_, err = parseContainerLogName("/var/log/containers/kubelet.log")
+	assert.Error(t, err)
+}
+
+func TestParseDockerLines(t *testing.T) {
+	p := &lineParser{partial: make(map[string]string)}
+
+	_, ok := p.parse(`{"log":"first part, ","stream":"stdout","time":"2019-01-10T10:00:00.000000001Z"}` + "\n")
+	assert.False(t, ok, "lines without a newline are partial")
+	_, ok = p.parse(`{"log":"an error\n","stream":"stderr","time":"2019-01-10T10:00:00.000000002Z"}` + "\n")
+	assert.True(t, ok)
+	entry, ok := p.parse(`{"log":"second part\n","stream":"stdout","time":"2019-01-10T10:00:00.000000003Z"}` + "\n")
+	assert.True(t, ok)
+	assert.Equal(t, containerLogEntry{time: "2019-01-10T10:00:00.000000003Z", stream: "stdout", message: "first part, second part"}, entry)
+}
+
+func TestParseCRILines(t *testing.T) {
+	p := &lineParser{partial: make(map[string]string)}
+
+	_, ok := p.parse("2019-01-10T10:00:00.000000001Z stdout P first part, \n")
+	assert.False(t, ok)
+	entry, ok := p.parse("2019-01-10T10:00:00.000000002Z stdout F second part\n")
+	assert.True(t, ok)
+	assert.Equal(t, containerLogEntry{time: "2019-01-10T10:00:00.000000002Z", stream: "stdout", message: "first part, second part"}, entry)
+
+	entry, ok = p.parse("2019-01-10T10:00:00.000000003Z stderr F\n")
+	assert.True(t, ok, "empty lines have no message")
+	assert.Equal(t, "", entry.message)
+}
+
+func TestSplitLinesAreBounded(t *testing.T) {
+	p := &lineParser{partial: make(map[string]string)}
+	part := strings.Repeat("x", 16*1024)
+
+	for i := 0; i < maxLogLine/len(part)-1; i++ {
+		_, ok := p.parse("2019-01-10T10:00:00.000000001Z stdout P " + part + "\n")
+		assert.False(t, ok)
+	}
+	entry, ok := p.parse("2019-01-10T10:00:00.000000002Z stdout P " + part + "\n")
+	assert.True(t, ok, "a line that never ends should be sent once over the limit")
+	assert.Len(t, entry.message, maxLogLine)
+	assert.Empty(t, p.partial)
+
+	entry, ok = p.parse("2019-01-10T10:00:00.000000003Z stdout F the end\n")
+	assert.True(t, ok)
+	assert.Equal(t, "the end", entry.message, "the rest of the line should follow")
+}
+
+func TestContainerLogsFollowAppendsAndRotations(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "containers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, containerLogFile)
+	assert.NoError(t, ioutil.WriteFile(path, []byte("2019-01-10T10:00:00.000000000Z stdout F logged before startup\n"), 0644))
+
+	c := NewContainerLogs(filepath.Join(dir, "*.log"), "node-1")
+	defer c.Close()
+
+	appendLine(t, path, "2019-01-10T10:00:01.000000000Z stdout F logged after startup\n")
+	m := decodeWithTimeout(t, c)
+	assert.Equal(t, "logged after startup", m["MESSAGE"], "the files found on startup should be tailed from their end")
+	assert.Equal(t, "k8s_content-public-read_content-public-read-7b9d6d8c5b-xk4dz_default", m["CONTAINER_NAME"])
+	assert.Equal(t, "default", m["NAMESPACE"])
+	assert.Equal(t, "stdout", m["STREAM"])
+	assert.Equal(t, "node-1", m["_HOSTNAME"])
+
+	appendLine(t, path, "2019-01-10T10:00:01.500000000Z stdout F logged before rotation\n")
+	assert.NoError(t, os.Rename(path, path+".1"))
+	assert.NoError(t, ioutil.WriteFile(path, []byte("2019-01-10T10:00:02.000000000Z stdout F logged after rotation\n"), 0644))
+	m = decodeWithTimeout(t, c)
+	assert.Equal(t, "logged before rotation", m["MESSAGE"], "the old file should be read until its end")
+	m = decodeWithTimeout(t, c)
+	assert.Equal(t, "logged after rotation", m["MESSAGE"], "the rotated file should be read from its start")
+
+	other := filepath.Join(dir, "other-pod_kube-system_other-container-abc.log")
+	assert.NoError(t, ioutil.WriteFile(other, []byte(`{"log":"new container\n","stream":"stderr","time":"2019-01-10T10:00:03Z"}`+"\n"), 0644))
+	m = decodeWithTimeout(t, c)
+	assert.Equal(t, "new container", m["MESSAGE"], "the files created after startup should be read from their start")
+	assert.Equal(t, "k8s_other-container_other-pod_kube-system", m["CONTAINER_NAME"])
+
+	c.Close()
+	assert.Equal(t, io.EOF, c.Decode(make(map[string]interface{})))
+}
+
+func TestContainerLogsFollowCopyTruncate(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "containers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, containerLogFile)
+	assert.NoError(t, ioutil.WriteFile(path, []byte("2019-01-10T10:00:00.000000000Z stdout F logged before startup\n"), 0644))
+
+	c := NewContainerLogs(filepath.Join(dir, "*.log"), "node-1")
+	defer c.Close()
+
+	appendLine(t, path, "2019-01-10T10:00:01.000000000Z stdout F logged after startup\n")
+	m := decodeWithTimeout(t, c)
+	assert.Equal(t, "logged after startup", m["MESSAGE"])
+
+	// waiting at the end of the file, truncated and written past the previous offset before the next poll
+	time.Sleep(5 * pollInterval)
+	assert.NoError(t, ioutil.WriteFile(path, []byte("2019-01-10T10:00:02.000000000Z stdout F logged after truncation, and longer than before\n"+
+		"2019-01-10T10:00:03.000000000Z stdout F logged after truncation too\n"), 0644))
+	m = decodeWithTimeout(t, c)
+	assert.Equal(t, "logged after truncation, and longer than before", m["MESSAGE"], "the truncated file should be read from its start")
+	m = decodeWithTimeout(t, c)
+	assert.Equal(t, "logged after truncation too", m["MESSAGE"])
+}
+
+func appendLine(t *testing.T, path string, line string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(line)
+	assert.NoError(t, err)
+}
+
+// decoder is implemented by all the inputs
+type decoder interface {
+	Decode(m map[string]interface{}) error
+}
+
+func decodeWithTimeout(t *testing.T, c decoder) map[string]interface{} {
+	decoded := make(chan map[string]interface{}, 1)
+	go func() {
+		m := make(map[string]interface{})
+		if err := c.Decode(m); err == nil {
+			decoded <- m
+		}
+	}()
+	select {
+	case m := <-decoded:
+		return m
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "nothing decoded")
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,83 @@
+// Package input reads the log messages from the sources other than journalctl's JSON output
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxFieldSize protects against allocating the size of a corrupted binary field
+const maxFieldSize = 64 * 1024 * 1024
+
+// ExportDecoder reads the journald export format, as produced by journalctl --output=export.
+// Every entry is a list of fields followed by an empty line. Text fields are written as NAME=value lines, while the fields
+// that may hold binary data are written as the name on its own line, followed by the size of the value as a little endian
+// 64 bit integer, the value and a newline. The values are kept byte for byte.
+type ExportDecoder struct {
+	r *bufio.Reader
+}
+
+func NewExportDecoder(r io.Reader) *ExportDecoder {
+	return &ExportDecoder{bufio.NewReader(r)}
+}
+
+// Decode reads the next entry into m. It returns io.EOF once there are no more entries.
+func (d *ExportDecoder) Decode(m map[string]interface{}) error {
+	fields := 0
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			if fields > 0 {
+				// the last entry isn't followed by an empty line when the export is cut short
+				return nil
+			}
+			return io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 {
+			if fields == 0 {
+				// tolerate the extra empty lines between entries
+				continue
+			}
+			return nil
+		}
+
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			m[string(line[:i])] = string(line[i+1:])
+		} else {
+			value, err := d.readBinary()
+			if err != nil {
+				return fmt.Errorf("invalid binary field %v: %v", string(line), err)
+			}
+			m[string(line)] = value
+		}
+		fields++
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (d *ExportDecoder) readBinary() (string, error) {
+	var size uint64
+	if err := binary.Read(d.r, binary.LittleEndian, &size); err != nil {
+		return "", err
+	}
+	if size > maxFieldSize {
+		return "", fmt.Errorf("value of %v bytes exceeds the limit of %v bytes", size, maxFieldSize)
+	}
+	value := make([]byte, size)
+	if _, err := io.ReadFull(d.r, value); err != nil {
+		return "", err
+	}
+	if b, err := d.r.ReadByte(); err != nil || b != '\n' {
+		return "", fmt.Errorf("value of %v bytes not followed by a newline", size)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,71 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/log-collector/filter"
+)
+
+func TestExportDecoder(t *testing.T) {
+	f, err := os.Open("example.export")
+	assert.NoError(t, err)
+	defer f.Close()
+	dec := NewExportDecoder(f)
+
+	first := make(map[string]interface{})
+	assert.NoError(t, dec.Decode(first))
+	assert.Equal(t, "docker.service", first["_SYSTEMD_UNIT"])
+	assert.Equal(t, `time="2018-12-19T09:20:54Z" level=info msg="Request processed" transaction_id=tid_abc123`, first["MESSAGE"], "the value should be kept whole, including the equals signs")
+	assert.Equal(t, "1545211254197572", first["__REALTIME_TIMESTAMP"])
+
+	second := make(map[string]interface{})
+	assert.NoError(t, dec.Decode(second))
+	assert.Equal(t, "first line\nsecond line=with an equals sign", second["MESSAGE"], "binary fields should be kept byte for byte")
+	assert.Equal(t, "kubelet.service", second["_SYSTEMD_UNIT"])
+
+	third := make(map[string]interface{})
+	assert.NoError(t, dec.Decode(third))
+	assert.Equal(t, "dropped by the filter", third["MESSAGE"])
+
+	assert.Equal(t, io.EOF, dec.Decode(make(map[string]interface{})))
+}
+
+func TestExportDecoderWithoutTrailingEmptyLine(t *testing.T) {
+	dec := NewExportDecoder(strings.NewReader("MESSAGE=hello\n_PID=1"))
+
+	m := make(map[string]interface{})
+	assert.NoError(t, dec.Decode(m))
+	assert.Equal(t, map[string]interface{}{"MESSAGE": "hello", "_PID": "1"}, m)
+	assert.Equal(t, io.EOF, dec.Decode(make(map[string]interface{})))
+}
+
+func TestExportDecoderTruncatedBinaryField(t *testing.T) {
+	dec := NewExportDecoder(strings.NewReader("MESSAGE\n\x10\x00\x00\x00\x00\x00\x00\x00short\n"))
+
+	assert.Error(t, dec.Decode(make(map[string]interface{})))
+}
+
+func TestFilterExportedEntries(t *testing.T) {
+	f, err := os.Open("example.export")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var out bytes.Buffer
+	filter.FilterEvents(NewExportDecoder(f), &out)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2, "the entry of the log-collector itself should be filtered out")
+	var first map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "content-public-read", first["SERVICE_NAME"])
+	assert.Equal(t, "tid_abc123", first["transaction_id"])
+	assert.Equal(t, "docker.service", first["SYSTEMD_UNIT"])
+	assert.Contains(t, first, "__CURSOR")
+}
@@ -0,0 +1,216 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/log-collector/metrics"
+)
+
+const (
+	// DefaultHTTPAddress is where the HTTP input listens
+	DefaultHTTPAddress = ":8082"
+	// EventsPath is the path the events are posted to
+	EventsPath = "/events"
+	// maxRequestBody limits the size of the uncompressed request bodies
+	maxRequestBody = 10 * 1024 * 1024
+)
+
+// HTTPInput receives the events pushed by the batch jobs and sidecars of the node. A request holds either a single JSON
+// event, newline delimited JSON events or a JSON array of events, optionally gzipped. The events with a MESSAGE are taken
+// as they are, like journald messages, while the MESSAGE of the other events is the event itself as JSON, whose fields the
+// filter extracts. While the sink queues are full the requests are rejected with 429 Too Many Requests.
+type HTTPInput struct {
+	host      string
+	saturated func() bool
+	listener  net.Listener
+	server    *http.Server
+	entries   chan map[string]interface{}
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	stopOnce  sync.Once
+}
+
+// NewHTTPInput listens on the address, saturated tells whether the pushed events have to be turned away
+func NewHTTPInput(address string, host string, saturated func() bool) (*HTTPInput, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	h := &HTTPInput{
+		host:      host,
+		saturated: saturated,
+		listener:  listener,
+		entries:   make(chan map[string]interface{}),
+		quit:      make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(EventsPath, h)
+	// stalled or idle clients are disconnected rather than holding their connection for good, like the sink clients do
+	h.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       90 * time.Second,
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		log.Printf("Receiving events on %v%v\n", address, EventsPath)
+		if err := h.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP input stopped: %v\n", err)
+		}
+	}()
+	return h, nil
+}
+
+// Decode waits for the next pushed event. It returns io.EOF once closed.
+func (h *HTTPInput) Decode(m map[string]interface{}) error {
+	select {
+	case entry := <-h.entries:
+		for k, v := range entry {
+			m[k] = v
+		}
+		return nil
+	case <-h.quit:
+		return io.EOF
+	}
+}
+
+// Close stops receiving events, the requests in progress are answered with 503 Service Unavailable
+func (h *HTTPInput) Close() error {
+	h.stopOnce.Do(func() {
+		close(h.quit)
+		h.server.Close()
+		h.wg.Wait()
+	})
+	return nil
+}
+
+func (h *HTTPInput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		h.respond(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+	if h.saturated() {
+		w.Header().Set("Retry-After", "1")
+		h.respond(w, http.StatusTooManyRequests, "the sink queues are full, retry later")
+		return
+	}
+
+	body := io.Reader(r.Body)
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			h.respond(w, http.StatusBadRequest, "invalid gzip body: "+err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		h.respond(w, http.StatusUnsupportedMediaType, "unsupported Content-Encoding "+r.Header.Get("Content-Encoding"))
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxRequestBody+1))
+	if err != nil {
+		h.respond(w, http.StatusBadRequest, "failed to read the body: "+err.Error())
+		return
+	}
+	if len(data) > maxRequestBody {
+		h.respond(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the body exceeds %v bytes", maxRequestBody))
+		return
+	}
+
+	// all the events of the request are validated first, so that a rejected request can be retried as a whole
+	events, err := parseEvents(data)
+	if err != nil {
+		h.respond(w, http.StatusBadRequest, "invalid events: "+err.Error())
+		return
+	}
+	sender := remoteHost(r.RemoteAddr)
+	for _, e := range events {
+		select {
+		case h.entries <- h.message(e, sender):
+		case <-h.quit:
+			h.respond(w, http.StatusServiceUnavailable, "shutting down")
+			return
+		case <-r.Context().Done():
+			// the sender gave up, it retries the events already taken too
+			return
+		}
+	}
+	h.respond(w, http.StatusAccepted, strconv.Itoa(len(events))+" events accepted")
+}
+
+func (h *HTTPInput) respond(w http.ResponseWriter, status int, message string) {
+	metrics.HTTPRequests.WithLabelValues(strconv.Itoa(status)).Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// message gives the event the fields of a journald message
+func (h *HTTPInput) message(e map[string]interface{}, sender string) map[string]interface{} {
+	m := e
+	if _, found := e["MESSAGE"]; !found {
+		j, _ := json.Marshal(e)
+		m = map[string]interface{}{"MESSAGE": string(j)}
+	}
+	m["_TRANSPORT"] = "http"
+	if _, found := m["_HOSTNAME"]; !found {
+		if h.host != "" {
+			m["_HOSTNAME"] = h.host
+		} else {
+			m["_HOSTNAME"] = sender
+		}
+	}
+	return m
+}
+
+// parseEvents parses a JSON array of events, or a stream of them which covers both a single event and newline delimited ones
+func parseEvents(data []byte) ([]map[string]interface{}, error) {
+	data = bytes.TrimSpace(data)
+	var events []map[string]interface{}
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &events); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var e map[string]interface{}
+			err := dec.Decode(&e)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, e)
+		}
+	}
+	for i, e := range events {
+		if e == nil {
+			return nil, fmt.Errorf("event %v is null", i)
+		}
+		if message, found := e["MESSAGE"]; found {
+			if _, ok := message.(string); !ok {
+				return nil, fmt.Errorf("the MESSAGE of event %v isn't a string", i)
+			}
+		}
+	}
+	return events, nil
+}
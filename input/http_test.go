@@ -0,0 +1,165 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/log-collector/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEvents(t *testing.T) {
+	events, err := parseEvents([]byte(`{"level":"info","msg":"single"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"level": "info", "msg": "single"}}, events)
+
+	events, err = parseEvents([]byte("{\"msg\":\"first\"}\n{\"msg\":\"second\"}\n"))
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	events, err = parseEvents([]byte(` [{"msg":"first"},{"MESSAGE":"second"}] `))
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	_, err = parseEvents([]byte("{\"msg\":\"first\"}\nnot json\n"))
+	assert.Error(t, err)
+	_, err = parseEvents([]byte(`[{"msg":"first"},null]`))
+	assert.Error(t, err)
+	_, err = parseEvents([]byte(`{"MESSAGE":42}`))
+	assert.Error(t, err, "the MESSAGE of a journald message is a string")
+}
+
+func TestHTTPInputAcceptsEvents(t *testing.T) {
+	h := newTestHTTPInput(t, false)
+	defer h.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		status <- post(h, "{\"level\":\"info\",\"msg\":\"job done\"}\n{\"MESSAGE\":\"plain\",\"SYSLOG_IDENTIFIER\":\"batch-job\"}\n", "")
+	}()
+
+	m := decodeWithTimeout(t, h)
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(m["MESSAGE"].(string)), &event), "the events without a MESSAGE should be sent as JSON")
+	assert.Equal(t, map[string]interface{}{"level": "info", "msg": "job done"}, event)
+	assert.Equal(t, "http", m["_TRANSPORT"])
+	assert.Equal(t, "node-1", m["_HOSTNAME"])
+
+	m = decodeWithTimeout(t, h)
+	assert.Equal(t, "plain", m["MESSAGE"])
+	assert.Equal(t, "batch-job", m["SYSLOG_IDENTIFIER"])
+
+	assert.Equal(t, http.StatusAccepted, <-status)
+}
+
+func TestHTTPInputAcceptsGzipBodies(t *testing.T) {
+	h := newTestHTTPInput(t, false)
+	defer h.Close()
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`[{"msg":"compressed"}]`))
+	gz.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		status <- post(h, body.String(), "gzip")
+	}()
+	assert.Contains(t, decodeWithTimeout(t, h)["MESSAGE"], "compressed")
+	assert.Equal(t, http.StatusAccepted, <-status)
+
+	assert.Equal(t, http.StatusBadRequest, post(h, "not gzipped", "gzip"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, post(h, "{}", "br"))
+}
+
+func TestHTTPInputRejectsRequests(t *testing.T) {
+	h := newTestHTTPInput(t, false)
+	defer h.Close()
+
+	assert.Equal(t, http.StatusBadRequest, post(h, `{"msg":`, ""))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, EventsPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, EventsPath, strings.NewReader(strings.Repeat(" ", maxRequestBody+1))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestHTTPInputAppliesBackpressure(t *testing.T) {
+	h := newTestHTTPInput(t, true)
+	defer h.Close()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, EventsPath, strings.NewReader(`{"msg":"too many"}`)))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestHTTPInputServesEvents(t *testing.T) {
+	h := newTestHTTPInput(t, false)
+
+	go func() {
+		resp, err := http.Post("http://"+h.listener.Addr().String()+EventsPath, "application/x-ndjson", strings.NewReader(`{"msg":"over the network"}`))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	assert.Contains(t, decodeWithTimeout(t, h)["MESSAGE"], "over the network")
+
+	h.Close()
+	assert.Equal(t, io.EOF, h.Decode(make(map[string]interface{})))
+}
+
+func TestHTTPInputSurvivesContainerNamesWithoutUnderscores(t *testing.T) {
+	h := newTestHTTPInput(t, false)
+	defer h.Close()
+
+	r, w := io.Pipe()
+	filtered := make(chan struct{})
+	go func() {
+		defer close(filtered)
+		filter.FilterEvents(h, w)
+		w.Close()
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		status <- post(h, "{\"MESSAGE\":\"x\",\"CONTAINER_NAME\":\"sidecar\"}\n{\"MESSAGE\":\"still running\"}\n", "")
+	}()
+	dec := json.NewDecoder(r)
+	for _, expected := range []string{"x", "still running"} {
+		m := make(map[string]interface{})
+		assert.NoError(t, dec.Decode(&m))
+		assert.Equal(t, expected, m["MESSAGE"])
+	}
+	assert.Equal(t, http.StatusAccepted, <-status)
+
+	h.Close()
+	go ioutil.ReadAll(r)
+	<-filtered
+}
+
+func newTestHTTPInput(t *testing.T, saturated bool) *HTTPInput {
+	h, err := NewHTTPInput("127.0.0.1:0", "node-1", func() bool { return saturated })
+	assert.NoError(t, err)
+	return h
+}
+
+func post(h *HTTPInput, body string, encoding string) int {
+	r := httptest.NewRequest(http.MethodPost, EventsPath, strings.NewReader(body))
+	if encoding != "" {
+		r.Header.Set("Content-Encoding", encoding)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
@@ -0,0 +1,162 @@
+package input
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Financial-Times/log-collector/filter"
+	"github.com/Financial-Times/log-collector/metrics"
+)
+
+// SourceField tags every log message with the name of the input it was read from
+const SourceField = "input_source"
+
+const (
+	SourceStdin      = "stdin"
+	SourceContainers = "containers"
+	SourceSyslog     = "syslog"
+	SourceHTTP       = "http"
+)
+
+// ParseSources parses the comma separated list of inputs, ignoring duplicates
+func ParseSources(names string) ([]string, error) {
+	var sources []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		switch name {
+		case SourceStdin, SourceContainers, SourceSyslog, SourceHTTP:
+		default:
+			return nil, fmt.Errorf("unknown input %q, expected any of %v, %v, %v or %v", name, SourceStdin, SourceContainers, SourceSyslog, SourceHTTP)
+		}
+		seen[name] = true
+		sources = append(sources, name)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("at least one input is required")
+	}
+	return sources, nil
+}
+
+// Source is one of the inputs merged into the pipeline
+type Source struct {
+	Name    string
+	Decoder filter.Decoder
+}
+
+// Merged reads the log messages of several inputs at once, each one in its own goroutine, and tags them with their source.
+// The inputs end independently, the merged input ends once all of them did. A decoding error of any input is returned as is.
+type Merged struct {
+	sources  []Source
+	counts   []int64
+	entries  chan decoded
+	quit     chan struct{}
+	done     chan struct{} //closed once every input ended
+	stopOnce sync.Once
+}
+
+type decoded struct {
+	m   map[string]interface{}
+	err error
+}
+
+func Merge(sources ...Source) *Merged {
+	s := &Merged{
+		sources: sources,
+		counts:  make([]int64, len(sources)),
+		entries: make(chan decoded),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for i := range sources {
+		go func(i int) {
+			defer wg.Done()
+			s.read(i)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(s.done)
+	}()
+	return s
+}
+
+func (s *Merged) read(i int) {
+	source := s.sources[i]
+	log.Printf("Input %v started\n", source.Name)
+	for {
+		m := make(map[string]interface{})
+		err := source.Decoder.Decode(m)
+		if err == io.EOF {
+			if !s.closed() {
+				log.Printf("Input %v completed after %v log messages\n", source.Name, atomic.LoadInt64(&s.counts[i]))
+			}
+			return
+		}
+		if err == nil {
+			m[SourceField] = source.Name
+			atomic.AddInt64(&s.counts[i], 1)
+			metrics.InputMessages.WithLabelValues(source.Name).Inc()
+		}
+		select {
+		case s.entries <- decoded{m, err}:
+		case <-s.quit:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Decode waits for the next log message of any input. It returns io.EOF once every input ended, or once closed.
+func (s *Merged) Decode(m map[string]interface{}) error {
+	select {
+	case d := <-s.entries:
+		if d.err != nil {
+			return d.err
+		}
+		for k, v := range d.m {
+			m[k] = v
+		}
+		return nil
+	case <-s.done:
+		return io.EOF
+	case <-s.quit:
+		return io.EOF
+	}
+}
+
+// Close stops the inputs that can be stopped, and logs how many log messages each input read
+func (s *Merged) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		for i, source := range s.sources {
+			if c, ok := source.Decoder.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					log.Printf("Failed to stop input %v: %v\n", source.Name, err)
+				}
+			}
+			log.Printf("Input %v stopped after %v log messages\n", source.Name, atomic.LoadInt64(&s.counts[i]))
+		}
+	})
+	return nil
+}
+
+func (s *Merged) closed() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,92 @@
+package input
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/log-collector/filter"
+)
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources(" stdin, syslog,stdin,,http ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stdin", "syslog", "http"}, sources)
+
+	_, err = ParseSources("stdin,kafka")
+	assert.Error(t, err)
+	_, err = ParseSources(" , ")
+	assert.Error(t, err)
+}
+
+func TestMergeTagsTheMessagesWithTheirSource(t *testing.T) {
+	journald := filter.NewJSONDecoder(strings.NewReader(`{"MESSAGE":"journald 1"}{"MESSAGE":"journald 2"}`))
+	syslog := &closableDecoder{quit: make(chan struct{}), messages: []string{"syslog 1"}}
+	merged := Merge(Source{"stdin", journald}, Source{"syslog", syslog})
+
+	read := map[string]string{}
+	for i := 0; i < 3; i++ {
+		m := decodeWithTimeout(t, merged)
+		read[m["MESSAGE"].(string)] = m[SourceField].(string)
+	}
+	assert.Equal(t, map[string]string{"journald 1": "stdin", "journald 2": "stdin", "syslog 1": "syslog"}, read)
+
+	ended := make(chan error, 1)
+	go func() {
+		ended <- merged.Decode(make(map[string]interface{}))
+	}()
+	select {
+	case <-ended:
+		assert.Fail(t, "the merged input should go on while the syslog input does")
+	default:
+	}
+
+	merged.Close()
+	assert.Equal(t, io.EOF, <-ended)
+	assert.True(t, syslog.closed, "the inputs should be stopped")
+}
+
+func TestMergeEndsWithItsInputs(t *testing.T) {
+	merged := Merge(
+		Source{"first", filter.NewJSONDecoder(strings.NewReader(`{"MESSAGE":"first"}`))},
+		Source{"second", filter.NewJSONDecoder(strings.NewReader(``))},
+	)
+	assert.Equal(t, "first", decodeWithTimeout(t, merged)["MESSAGE"])
+	assert.Equal(t, io.EOF, merged.Decode(make(map[string]interface{})))
+}
+
+func TestMergeReturnsDecodingErrors(t *testing.T) {
+	merged := Merge(Source{"stdin", filter.NewJSONDecoder(strings.NewReader(`{"MESSAGE":`))})
+	err := merged.Decode(make(map[string]interface{}))
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+// closableDecoder returns its messages, then blocks until closed
+type closableDecoder struct {
+	messages []string
+	quit     chan struct{}
+	closed   bool
+}
+
+func (d *closableDecoder) Decode(m map[string]interface{}) error {
+	if len(d.messages) > 0 {
+		m["MESSAGE"] = d.messages[0]
+		d.messages = d.messages[1:]
+		return nil
+	}
+	<-d.quit
+	return io.EOF
+}
+
+func (d *closableDecoder) Close() error {
+	if d.closed {
+		return errors.New("already closed")
+	}
+	d.closed = true
+	close(d.quit)
+	return nil
+}
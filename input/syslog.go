@@ -0,0 +1,403 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSyslogAddress is where the syslog input listens, on both UDP and TCP
+const DefaultSyslogAddress = ":5514"
+
+const (
+	// maxSyslogMessage is the largest message accepted, the limit of a UDP datagram
+	maxSyslogMessage = 64 * 1024
+	// defaultPriority is user.notice, given to the messages without a priority as per RFC 3164
+	defaultPriority = 13
+	// utf8BOM may start the message of RFC 5424
+	utf8BOM = "\xEF\xBB\xBF"
+)
+
+// syslogIdleTimeout is how long a TCP connection is kept open without receiving a message
+var syslogIdleTimeout = 5 * time.Minute
+
+// the names of the severities and facilities, as numbered by RFC 5424
+var (
+	severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	facilityNames = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+		"ntp", "security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+)
+
+// SyslogServer receives the syslog messages of the appliances and VMs that can't run the log-collector, on both UDP and TCP.
+// Messages in the RFC 3164 (BSD) and RFC 5424 formats are decoded into the fields journald gives the messages it receives
+// over syslog: PRIORITY, SYSLOG_FACILITY, SYSLOG_IDENTIFIER, SYSLOG_PID, _HOSTNAME and MESSAGE. The filter removes the first
+// three by default, so they are also given by name as severity, facility and app_name.
+// Over TCP the messages are either framed with octet counting or terminated by a newline, as per RFC 6587.
+type SyslogServer struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	entries  chan map[string]interface{}
+	quit     chan struct{}
+	conns    map[net.Conn]bool
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+func NewSyslogServer(address string) (*SyslogServer, error) {
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	s := &SyslogServer{
+		udp:     udp,
+		tcp:     tcp,
+		entries: make(chan map[string]interface{}),
+		quit:    make(chan struct{}),
+		conns:   make(map[net.Conn]bool),
+	}
+	s.wg.Add(2)
+	go s.receiveDatagrams()
+	go s.acceptConnections()
+	log.Printf("Listening for syslog messages on %v\n", address)
+	return s, nil
+}
+
+// Decode waits for the next syslog message. It returns io.EOF once closed.
+func (s *SyslogServer) Decode(m map[string]interface{}) error {
+	select {
+	case entry := <-s.entries:
+		for k, v := range entry {
+			m[k] = v
+		}
+		return nil
+	case <-s.quit:
+		return io.EOF
+	}
+}
+
+// Close stops listening and drops the open connections
+func (s *SyslogServer) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.udp.Close()
+		s.tcp.Close()
+		s.lock.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.lock.Unlock()
+		s.wg.Wait()
+	})
+	return nil
+}
+
+// receiveDatagrams handles the UDP messages, one per datagram
+func (s *SyslogServer) receiveDatagrams() {
+	defer s.wg.Done()
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if s.closed() {
+				return
+			}
+			log.Printf("Failed to receive syslog datagram: %v\n", err)
+			continue
+		}
+		s.send(parseSyslog(buf[:n], time.Now(), remoteHost(addr.String())))
+	}
+}
+
+func (s *SyslogServer) acceptConnections() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if s.closed() {
+				return
+			}
+			log.Printf("Failed to accept syslog connection: %v\n", err)
+			continue
+		}
+		s.lock.Lock()
+		if s.closed() {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go s.receiveStream(conn)
+	}
+}
+
+// receiveStream handles the TCP messages of the connection until the sender closes it
+func (s *SyslogServer) receiveStream(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+
+	host := remoteHost(conn.RemoteAddr().String())
+	r := bufio.NewReader(conn)
+	for {
+		// the senders that went away without closing their connection would otherwise hold it for good
+		conn.SetReadDeadline(time.Now().Add(syslogIdleTimeout))
+		frame, err := readFrame(r)
+		if err == io.EOF || s.closed() {
+			return
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			log.Printf("Closing syslog connection from %v, idle for %v\n", conn.RemoteAddr(), syslogIdleTimeout)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read syslog message from %v: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		if len(frame) > 0 {
+			s.send(parseSyslog(frame, time.Now(), host))
+		}
+	}
+}
+
+func (s *SyslogServer) send(entry map[string]interface{}) {
+	select {
+	case s.entries <- entry:
+	case <-s.quit:
+	}
+}
+
+func (s *SyslogServer) closed() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// remoteHost is the host of the sender's host:port address
+func remoteHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// readFrame reads the next TCP message, framed either by octet counting, i.e. "<length> <message>", or by a trailing newline
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		// read a buffer at a time, so that a sender that never ends the line can't take more than the limit
+		var line []byte
+		for {
+			chunk, err := r.ReadSlice('\n')
+			if len(line)+len(chunk) > maxSyslogMessage {
+				return nil, fmt.Errorf("message exceeds the limit of %v bytes", maxSyslogMessage)
+			}
+			line = append(line, chunk...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF && len(line) > 0 {
+				// the last message before the sender closed the connection
+				err = nil
+			}
+			return bytes.TrimRight(line, "\r\n"), err
+		}
+	}
+
+	size := 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' {
+			break
+		}
+		if b < '0' || b > '9' {
+			return nil, errors.New("invalid octet count")
+		}
+		size = size*10 + int(b-'0')
+		if size > maxSyslogMessage {
+			return nil, fmt.Errorf("message exceeds the limit of %v bytes", maxSyslogMessage)
+		}
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// parseSyslog decodes a message in either of the RFC 3164 and RFC 5424 formats. The parts missing from the message are
+// taken from the sender and the time it was received at.
+func parseSyslog(msg []byte, received time.Time, sender string) map[string]interface{} {
+	s := strings.TrimRight(string(msg), "\r\n\x00")
+	m := map[string]interface{}{"_TRANSPORT": "syslog"}
+
+	priority, rest, ok := parsePriority(s)
+	if !ok {
+		priority, rest = defaultPriority, s
+	}
+	m["PRIORITY"] = strconv.Itoa(priority % 8)
+	m["SYSLOG_FACILITY"] = strconv.Itoa(priority / 8)
+
+	if strings.HasPrefix(rest, "1 ") {
+		parseRFC5424(rest[2:], m)
+	} else {
+		parseRFC3164(rest, received, m)
+	}
+
+	m["severity"] = severityNames[priority%8]
+	m["facility"] = facilityNames[priority/8]
+	if id, found := m["SYSLOG_IDENTIFIER"]; found {
+		m["app_name"] = id
+	}
+	if _, found := m["_HOSTNAME"]; !found {
+		m["_HOSTNAME"] = sender
+	}
+	if _, found := m["SYSLOG_TIMESTAMP"]; !found {
+		m["SYSLOG_TIMESTAMP"] = received.UTC().Format(time.RFC3339Nano)
+	}
+	return m
+}
+
+// parsePriority parses the <facility * 8 + severity> prefix
+func parsePriority(s string) (int, string, bool) {
+	end := strings.IndexByte(s, '>')
+	if !strings.HasPrefix(s, "<") || end < 2 || end > 4 {
+		return 0, s, false
+	}
+	priority, err := strconv.Atoi(s[1:end])
+	if err != nil || priority < 0 || priority > 191 {
+		return 0, s, false
+	}
+	return priority, s[end+1:], true
+}
+
+// parseRFC5424 parses the part after the version, e.g.
+// 2019-01-10T10:00:00.000Z host app 1234 ID47 [exampleSDID@32473 eventSource="Application"] message
+// where - stands for the missing parts
+func parseRFC5424(s string, m map[string]interface{}) {
+	next := func() string {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			token := s
+			s = ""
+			return token
+		}
+		token := s[:i]
+		s = s[i+1:]
+		return token
+	}
+	for _, field := range []string{"SYSLOG_TIMESTAMP", "_HOSTNAME", "SYSLOG_IDENTIFIER", "SYSLOG_PID", "SYSLOG_MSGID"} {
+		if token := next(); token != "-" && token != "" {
+			m[field] = token
+		}
+	}
+
+	var data string
+	data, s = splitStructuredData(s)
+	if data != "" {
+		m["SYSLOG_STRUCTURED_DATA"] = data
+	}
+	m["MESSAGE"] = strings.TrimPrefix(strings.TrimPrefix(s, " "), utf8BOM)
+}
+
+// splitStructuredData splits the [id param="value"] elements from the message. The values escape the ", \ and ] characters.
+func splitStructuredData(s string) (string, string) {
+	if strings.HasPrefix(s, "-") {
+		return "", s[1:]
+	}
+	end := 0
+	for end < len(s) && s[end] == '[' {
+		quoted := false
+		i := end + 1
+		for ; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				quoted = !quoted
+			} else if s[i] == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(s) {
+			// unterminated, keep it all as the message
+			return "", s
+		}
+		end = i + 1
+	}
+	return s[:end], s[end:]
+}
+
+// parseRFC3164 parses the part after the priority, e.g.
+// Jan 10 10:00:00 host app[1234]: message
+// The timestamp has neither a year nor a time zone, the year of the reception and UTC are assumed.
+// Without a valid timestamp all of it is the message, as per the RFC.
+func parseRFC3164(s string, received time.Time, m map[string]interface{}) {
+	if len(s) < len(time.Stamp) {
+		m["MESSAGE"] = s
+		return
+	}
+	t, err := time.Parse(time.Stamp, s[:len(time.Stamp)])
+	if err != nil {
+		m["MESSAGE"] = s
+		return
+	}
+	received = received.UTC()
+	t = time.Date(received.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	if t.Sub(received) > 24*time.Hour {
+		// sent in December, received in January
+		t = t.AddDate(-1, 0, 0)
+	}
+	m["SYSLOG_TIMESTAMP"] = t.Format(time.RFC3339Nano)
+	s = strings.TrimPrefix(s[len(time.Stamp):], " ")
+
+	// the hostname is left out by some senders, the tag is recognised by its trailing colon or the pid
+	if i := strings.IndexByte(s, ' '); i > 0 && !strings.ContainsAny(s[:i], ":[") {
+		m["_HOSTNAME"] = s[:i]
+		s = s[i+1:]
+	}
+
+	if i := strings.IndexAny(s, ":[ "); i > 0 && s[i] != ' ' {
+		tag, rest := s[:i], s[i:]
+		if rest[0] == '[' {
+			end := strings.Index(rest, "]")
+			if end < 0 || !strings.HasPrefix(rest[end+1:], ":") {
+				m["MESSAGE"] = s
+				return
+			}
+			m["SYSLOG_PID"] = rest[1:end]
+			rest = rest[end+1:]
+		}
+		m["SYSLOG_IDENTIFIER"] = tag
+		s = strings.TrimPrefix(rest[1:], " ")
+	}
+	m["MESSAGE"] = s
+}
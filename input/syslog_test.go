@@ -0,0 +1,194 @@
+package input
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/log-collector/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+var received = time.Date(2019, time.January, 10, 10, 0, 5, 0, time.UTC)
+
+func TestParseRFC3164(t *testing.T) {
+	m := parseSyslog([]byte("<34>Jan 10 10:00:00 appliance-1 sshd[1234]: Failed password for root\n"), received, "10.0.0.1")
+	assert.Equal(t, map[string]interface{}{
+		"_TRANSPORT":        "syslog",
+		"PRIORITY":          "2",
+		"SYSLOG_FACILITY":   "4",
+		"severity":          "crit",
+		"facility":          "auth",
+		"SYSLOG_TIMESTAMP":  "2019-01-10T10:00:00Z",
+		"_HOSTNAME":         "appliance-1",
+		"SYSLOG_IDENTIFIER": "sshd",
+		"app_name":          "sshd",
+		"SYSLOG_PID":        "1234",
+		"MESSAGE":           "Failed password for root",
+	}, m)
+}
+
+func TestParseRFC3164WithoutHostname(t *testing.T) {
+	m := parseSyslog([]byte("<13>Jan  2 10:00:00 cron: job done"), received, "10.0.0.1")
+	assert.Equal(t, "2019-01-02T10:00:00Z", m["SYSLOG_TIMESTAMP"])
+	assert.Equal(t, "10.0.0.1", m["_HOSTNAME"], "the sender should stand for the missing hostname")
+	assert.Equal(t, "cron", m["SYSLOG_IDENTIFIER"])
+	assert.Equal(t, "job done", m["MESSAGE"])
+}
+
+func TestParseRFC3164FromLastYear(t *testing.T) {
+	m := parseSyslog([]byte("<13>Dec 31 23:59:59 vm-1 app: message"), received, "10.0.0.1")
+	assert.Equal(t, "2018-12-31T23:59:59Z", m["SYSLOG_TIMESTAMP"])
+}
+
+func TestParseWithoutTimestampOrPriority(t *testing.T) {
+	m := parseSyslog([]byte("just a message: with a colon"), received, "10.0.0.1")
+	assert.Equal(t, "5", m["PRIORITY"], "user.notice should be assumed")
+	assert.Equal(t, "1", m["SYSLOG_FACILITY"])
+	assert.Equal(t, "just a message: with a colon", m["MESSAGE"])
+	assert.Equal(t, "2019-01-10T10:00:05Z", m["SYSLOG_TIMESTAMP"])
+	assert.Nil(t, m["SYSLOG_IDENTIFIER"])
+	assert.Nil(t, m["app_name"])
+}
+
+func TestParseRFC5424(t *testing.T) {
+	m := parseSyslog([]byte(`<165>1 2019-01-10T10:00:00.003Z vm-1.ft.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\]lication"][examplePriority@32473 class="high"] `+utf8BOM+"An application event"), received, "10.0.0.1")
+	assert.Equal(t, map[string]interface{}{
+		"_TRANSPORT":             "syslog",
+		"PRIORITY":               "5",
+		"SYSLOG_FACILITY":        "20",
+		"severity":               "notice",
+		"facility":               "local4",
+		"SYSLOG_TIMESTAMP":       "2019-01-10T10:00:00.003Z",
+		"_HOSTNAME":              "vm-1.ft.com",
+		"SYSLOG_IDENTIFIER":      "evntslog",
+		"app_name":               "evntslog",
+		"SYSLOG_MSGID":           "ID47",
+		"SYSLOG_STRUCTURED_DATA": `[exampleSDID@32473 iut="3" eventSource="App\]lication"][examplePriority@32473 class="high"]`,
+		"MESSAGE":                "An application event",
+	}, m)
+}
+
+func TestParseRFC5424WithoutStructuredDataOrMessage(t *testing.T) {
+	m := parseSyslog([]byte("<14>1 - - app 42 - -"), received, "10.0.0.1")
+	assert.Equal(t, "10.0.0.1", m["_HOSTNAME"])
+	assert.Equal(t, "2019-01-10T10:00:05Z", m["SYSLOG_TIMESTAMP"])
+	assert.Equal(t, "app", m["SYSLOG_IDENTIFIER"])
+	assert.Equal(t, "42", m["SYSLOG_PID"])
+	assert.Nil(t, m["SYSLOG_STRUCTURED_DATA"])
+	assert.Equal(t, "", m["MESSAGE"])
+}
+
+func TestSyslogServerReceivesUDPAndTCP(t *testing.T) {
+	s, err := NewSyslogServer("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	udp, err := net.Dial("udp", s.udp.LocalAddr().String())
+	assert.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("<13>Jan 10 10:00:00 vm-1 app: over udp\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "over udp", decodeWithTimeout(t, s)["MESSAGE"])
+
+	tcp, err := net.Dial("tcp", s.tcp.Addr().String())
+	assert.NoError(t, err)
+	octetCounted := "<14>1 2019-01-10T10:00:00Z vm-1 app - - - counted\nframe"
+	fmt.Fprintf(tcp, "%d %s", len(octetCounted), octetCounted)
+	fmt.Fprint(tcp, "<13>Jan 10 10:00:00 vm-1 app: first line\r\n<13>Jan 10 10:00:00 vm-1 app: last line")
+	tcp.Close()
+
+	assert.Equal(t, "counted\nframe", decodeWithTimeout(t, s)["MESSAGE"], "octet counted frames may hold newlines")
+	assert.Equal(t, "first line", decodeWithTimeout(t, s)["MESSAGE"])
+	assert.Equal(t, "last line", decodeWithTimeout(t, s)["MESSAGE"], "the message before the connection is closed should be kept")
+
+	s.Close()
+	assert.Equal(t, io.EOF, s.Decode(make(map[string]interface{})))
+}
+
+func TestSyslogServerClosesIdleConnections(t *testing.T) {
+	defer func(timeout time.Duration) { syslogIdleTimeout = timeout }(syslogIdleTimeout)
+	syslogIdleTimeout = 50 * time.Millisecond
+	s, err := NewSyslogServer("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	tcp, err := net.Dial("tcp", s.tcp.Addr().String())
+	assert.NoError(t, err)
+	defer tcp.Close()
+	fmt.Fprint(tcp, "<13>Jan 10 10:00:00 vm-1 app: before going quiet\n")
+	assert.Equal(t, "before going quiet", decodeWithTimeout(t, s)["MESSAGE"])
+
+	tcp.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = tcp.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err, "the idle connection should be closed by the server")
+}
+
+func TestReadFrameLimit(t *testing.T) {
+	_, err := readFrame(bufio.NewReader(endlessLine{}))
+	if assert.Error(t, err, "a line that never ends should be rejected once over the limit") {
+		assert.Contains(t, err.Error(), "exceeds the limit")
+	}
+
+	frame, err := readFrame(bufio.NewReader(strings.NewReader(strings.Repeat("x", maxSyslogMessage-1) + "\n")))
+	assert.NoError(t, err)
+	assert.Len(t, frame, maxSyslogMessage-1)
+}
+
+// endlessLine is a sender that never ends its line
+type endlessLine struct{}
+
+func (endlessLine) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestSyslogFieldsAreKeptByTheFilter(t *testing.T) {
+	s, err := NewSyslogServer("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	r, w := io.Pipe()
+	filtered := make(chan struct{})
+	go func() {
+		defer close(filtered)
+		filter.FilterEvents(s, w)
+		w.Close()
+	}()
+
+	udp, err := net.Dial("udp", s.udp.LocalAddr().String())
+	assert.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("<34>Jan 10 10:00:00 appliance-1 sshd[1234]: Failed password for root\n"))
+	assert.NoError(t, err)
+
+	decoded := make(chan map[string]interface{}, 1)
+	go func() {
+		m := make(map[string]interface{})
+		if err := json.NewDecoder(r).Decode(&m); err == nil {
+			decoded <- m
+		}
+		io.Copy(ioutil.Discard, r)
+	}()
+	select {
+	case m := <-decoded:
+		assert.Equal(t, "Failed password for root", m["MESSAGE"])
+		assert.Equal(t, "crit", m["severity"])
+		assert.Equal(t, "auth", m["facility"])
+		assert.Equal(t, "sshd", m["app_name"])
+		assert.NotContains(t, m, "PRIORITY", "the journald fields should still be removed by default")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "nothing filtered")
+	}
+
+	s.Close()
+	<-filtered
+}
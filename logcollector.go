@@ -8,21 +8,62 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Financial-Times/log-collector/filter"
 	"github.com/Financial-Times/log-collector/forwarder"
+	"github.com/Financial-Times/log-collector/input"
 )
 
-var logsReader io.Reader
+var (
+	logsReader    io.Reader
+	inputSources  string
+	inputFormat   string
+	containerLogs string
+	syslogAddress string
+	httpAddress   string
+	rulesFile     string
+	rulesWatch    time.Duration
+	pseudonymKey  string
+)
 
 func init() {
 	flag.StringVar(&forwarder.Env, "env", "dummy", "Environment tag value")
 	flag.IntVar(&forwarder.Workers, "workers", 8, "Number of concurrent Workers")
 	flag.IntVar(&forwarder.ChanBuffer, "buffer", 256, "Channel buffer size")
 	flag.IntVar(&forwarder.Batchsize, "batchsize", 10, "Number of messages to group (before writing to S3 and delivering to Splunk HEC)")
+	flag.IntVar(&forwarder.BatchBytes, "batchbytes", 1024*1024, "Maximum size in bytes of the batches once encoded as HEC events, before compression, so that the batches stay under the S3 and Splunk HEC limits. No limit if 0")
 	flag.IntVar(&forwarder.Batchtimer, "batchtimer", 5, "Expiry in seconds after which delivering events to S3")
 	flag.StringVar(&forwarder.Bucket, "bucketName", "", "S3 Bucket where all the log events will be forwarded and stored")
 	flag.StringVar(&forwarder.AwsRegion, "awsRegion", "", "AWS region for S3")
+	flag.StringVar(&forwarder.Compression, "compression", "none", "Compression of the S3 objects: none, gzip or zstd")
+	flag.StringVar(&forwarder.KeyTemplate, "keyTemplate", forwarder.DefaultKeyTemplate, "Go template of the S3 object keys. Available fields: Env, Host, Year, Month, Day, Hour, Time, UnixNano, BatchID, UUID, Stream and Seq")
+	flag.StringVar(&forwarder.Host, "host", os.Getenv("NODE_NAME"), "Name of the node, used in the S3 object keys. Defaults to the NODE_NAME environment variable")
+	flag.StringVar(&forwarder.Sinks, "sinks", "s3", "Comma separated list of the destinations every batch is delivered to: s3, hec, file or stdout")
+	flag.StringVar(&forwarder.FileSinkDir, "fileSinkDir", "", "Directory where the file sink stores the batches")
+	flag.StringVar(&forwarder.HECURL, "hecURL", "", "Base URL of the Splunk HTTP Event Collector used by the hec sink, e.g. https://http-inputs-ft.splunkcloud.com")
+	flag.StringVar(&forwarder.HECToken, "hecToken", os.Getenv("HEC_TOKEN"), "Splunk HTTP Event Collector token. Defaults to the HEC_TOKEN environment variable")
+	flag.BoolVar(&forwarder.Ordered, "ordered", false, "Deliver the batches in order, one at a time per sink, and number the events so that their order can be restored")
+	flag.StringVar(&forwarder.Backpressure, "backpressure", "block", "What to do with new batches when the queue of a sink is full: block, drop-oldest, drop-newest, drop-by-level or spill")
+	flag.StringVar(&forwarder.DropLevels, "dropLevels", "trace,debug", "Comma separated levels of the events dropped while a queue is full, with -backpressure=drop-by-level")
+	flag.StringVar(&forwarder.CursorFile, "cursorFile", "", "File where the journald cursor of the last delivered event is kept, to resume from with journalctl --after-cursor. Disabled if empty")
+	flag.StringVar(&forwarder.SpoolDir, "spoolDir", "", "Directory where batches are kept until delivered to S3, and replayed from on restart. Spooling is disabled if empty")
+	flag.IntVar(&forwarder.MaxAttempts, "maxAttempts", 5, "Maximum number of attempts for delivering a batch to each sink. A failing batch holds its worker, and with -ordered the whole sink, during the retries: up to 15 seconds of backoff with the defaults, besides the attempts themselves and any longer Retry-After asked for by the sink, within -maxBatchAge")
+	flag.DurationVar(&forwarder.RetryBackoff, "retryBackoff", time.Second, "Delay before the first retry of a failed delivery, doubled on every following retry")
+	flag.DurationVar(&forwarder.MaxRetryBackoff, "maxRetryBackoff", 10*time.Second, "Upper limit of the delay between retries")
+	flag.DurationVar(&forwarder.MaxBatchAge, "maxBatchAge", time.Hour, "Age after which a batch is not retried anymore. No limit if 0")
+	flag.StringVar(&forwarder.DeadLetterDir, "deadLetterDir", "", "Directory where the batches that exhausted their retries are stored")
+	flag.StringVar(&forwarder.DeadLetterBucket, "deadLetterBucket", "", "S3 Bucket where the batches that exhausted their retries are stored. Takes precedence over -deadLetterDir")
+	flag.StringVar(&adminAddress, "adminAddress", ":8080", "Address of the HTTP server of the /metrics, /__health, /__gtg and /__build-info endpoints. Disabled if empty")
+	flag.DurationVar(&forwarder.HealthWindow, "healthWindow", 10*time.Minute, "Time without reading any log message, or with a sink failing, after which the log-collector is unhealthy")
+	flag.StringVar(&inputSources, "input", "stdin", "Comma separated list of the inputs the log messages are read from at once: stdin, containers for tailing the log files of the Kubernetes containers, syslog for receiving syslog messages, or http for receiving the events posted to /events")
+	flag.StringVar(&containerLogs, "containerLogs", input.DefaultContainerLogs, "Pattern of the container log files tailed with -input=containers")
+	flag.StringVar(&syslogAddress, "syslogAddress", input.DefaultSyslogAddress, "Address the syslog messages are received on with -input=syslog, over both UDP and TCP")
+	flag.StringVar(&httpAddress, "httpAddress", input.DefaultHTTPAddress, "Address the events are posted to with -input=http")
+	flag.StringVar(&inputFormat, "inputFormat", "json", "Format of the log messages read from the standard input: json (journalctl --output=json) or export (journalctl --output=export)")
+	flag.StringVar(&rulesFile, "rulesFile", "", "YAML or JSON file of the rules deciding which log messages are dropped and how the properties are cleaned up. The built-in rules are used if empty")
+	flag.DurationVar(&rulesWatch, "rulesWatchInterval", 10*time.Second, "How often the rules file is checked for changes, which are reloaded. The rules file is also reloaded on SIGHUP. Not checked if 0")
+	flag.StringVar(&pseudonymKey, "pseudonymKeyFile", "", "File of the secret key, of at least 32 bytes, the user identifiers listed by the pseudonymise rules are replaced with the HMAC of. Must be the same on every node")
 	flag.StringVar(&filter.DNSAddress, "dnsAddress", "", "The DNS entry of the full cluster, in case this env is regional. Example upp-prod-delivery.ft.com")
 }
 
@@ -32,19 +73,21 @@ func main() {
 	}
 	filter.Env = forwarder.Env
 	validateConfig()
+	startAdminServer()
 
 	forwarderIn, logFilterOut := io.Pipe()
 	var wg sync.WaitGroup
 	wg.Add(1)
 
 	go launchForwarder(forwarderIn, &wg)
-	go watchTerminationSignals(logFilterOut)
-
-	if logsReader == nil {
-		logsReader = os.Stdin
+	go watchReloadSignals()
+	if rulesFile != "" && rulesWatch > 0 {
+		go filter.WatchRules(rulesWatch, nil)
 	}
+	dec := newDecoder()
+	go watchTerminationSignals(logFilterOut, dec)
 
-	filter.Filter(logsReader, logFilterOut)
+	filter.FilterEvents(dec, logFilterOut)
 
 	// closing the writer will finish the forwarder
 	closeWriter(logFilterOut)
@@ -53,10 +96,93 @@ func main() {
 }
 
 func validateConfig() {
-	if len(forwarder.Bucket) == 0 { //Check whether -Bucket parameter value was provided
-		flag.Usage()
-		os.Exit(1) //If not fail visibly as we are unable to send logs to S3
+	if _, err := input.ParseSources(inputSources); err != nil {
+		failConfig(err.Error())
+	}
+	if inputFormat != "json" && inputFormat != "export" {
+		failConfig("Unknown -inputFormat " + inputFormat)
+	}
+	if pseudonymKey != "" {
+		if err := filter.LoadPseudonymKey(pseudonymKey); err != nil {
+			failConfig(err.Error())
+		}
+	}
+	if rulesFile != "" {
+		if err := filter.UseRulesFile(rulesFile); err != nil {
+			failConfig(err.Error())
+		}
+	}
+	if err := forwarder.ValidateBackpressure(forwarder.Backpressure); err != nil {
+		failConfig(err.Error())
+	}
+	if forwarder.Backpressure == "spill" && len(forwarder.SpoolDir) == 0 {
+		failConfig("The spill backpressure policy requires -spoolDir")
+	}
+	if len(forwarder.CursorFile) > 0 && len(forwarder.SpoolDir) == 0 {
+		failConfig("-cursorFile requires -spoolDir, which replays the failed batches the cursor moves past")
+	}
+	sinks, err := forwarder.ParseSinks(forwarder.Sinks)
+	if err != nil {
+		failConfig(err.Error())
+	}
+	for _, sink := range sinks {
+		switch sink {
+		case "s3":
+			if len(forwarder.Bucket) == 0 { //Check whether -Bucket parameter value was provided
+				failConfig("The s3 sink requires -bucketName") //If not fail visibly as we are unable to send logs to S3
+			}
+		case "hec":
+			if len(forwarder.HECURL) == 0 || len(forwarder.HECToken) == 0 {
+				failConfig("The hec sink requires -hecURL and a HEC token")
+			}
+		case "file":
+			if len(forwarder.FileSinkDir) == 0 {
+				failConfig("The file sink requires -fileSinkDir")
+			}
+		}
+	}
+}
+
+func failConfig(reason string) {
+	log.Println(reason)
+	flag.Usage()
+	os.Exit(1)
+}
+
+// newDecoder starts every input, the log messages of all of them are merged into the pipeline
+func newDecoder() filter.Decoder {
+	names, _ := input.ParseSources(inputSources)
+	var sources []input.Source
+	for _, name := range names {
+		sources = append(sources, input.Source{Name: name, Decoder: newInput(name)})
+	}
+	return input.Merge(sources...)
+}
+
+func newInput(name string) filter.Decoder {
+	switch name {
+	case input.SourceContainers:
+		return input.NewContainerLogs(containerLogs, forwarder.Host)
+	case input.SourceSyslog:
+		s, err := input.NewSyslogServer(syslogAddress)
+		if err != nil {
+			log.Fatalf("Failed to listen for syslog messages on %v: %v", syslogAddress, err)
+		}
+		return s
+	case input.SourceHTTP:
+		h, err := input.NewHTTPInput(httpAddress, forwarder.Host, forwarder.Saturated)
+		if err != nil {
+			log.Fatalf("Failed to listen for events on %v: %v", httpAddress, err)
+		}
+		return h
+	}
+	if logsReader == nil {
+		logsReader = os.Stdin
 	}
+	if inputFormat == "export" {
+		return input.NewExportDecoder(logsReader)
+	}
+	return filter.NewJSONDecoder(logsReader)
 }
 
 func launchForwarder(forwarderIn io.Reader, wg *sync.WaitGroup) {
@@ -64,13 +190,27 @@ func launchForwarder(forwarderIn io.Reader, wg *sync.WaitGroup) {
 	wg.Done()
 }
 
-func watchTerminationSignals(logFilterOut io.Closer) {
+func watchTerminationSignals(logFilterOut io.Closer, dec filter.Decoder) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
 	<-quit
 	log.Println("Received shutdown signal: exiting gracefully")
 	// closing the writer will finish the forwarder
 	closeWriter(logFilterOut)
+	// unlike stdin, the inputs that never reach their end have to be stopped
+	if c, ok := dec.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// watchReloadSignals reloads the rules file on SIGHUP
+func watchReloadSignals() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	for range reload {
+		log.Println("Received reload signal: reloading the rules")
+		filter.ReloadRules()
+	}
 }
 
 func closeWriter(logFilterOut io.Closer) {
@@ -20,8 +20,8 @@ type s3ServiceMock struct {
 
 var s3Mock = &s3ServiceMock{}
 
-func (s3 *s3ServiceMock) Put(obj string) error {
-	obj = strings.Replace(obj, "dispatch", "safe", -1)
+func (s3 *s3ServiceMock) Put(b *forwarder.Batch) error {
+	obj := strings.Replace(b.Body, "dispatch", "safe", -1)
 	obj = strings.Replace(obj, "error", "dispatch", -1)
 	s3.Lock()
 	s3.cache = append(s3.cache, obj)
@@ -36,6 +36,8 @@ func init() {
 	forwarder.Batchsize = 10
 	forwarder.Batchtimer = 5
 	forwarder.Bucket = "testbucket"
+	forwarder.Sinks = "s3"
+	adminAddress = ""
 
 	forwarder.NewS3Service = func(string, string, string) (forwarder.S3Service, error) {
 		return s3Mock, nil
@@ -0,0 +1,174 @@
+// Package metrics holds the Prometheus metrics of the log-collector pipeline
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "log_collector"
+
+var (
+	// LinesRead counts the log messages read from the input
+	LinesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lines_read_total",
+		Help:      "Log messages read from the input.",
+	})
+
+	// InputMessages counts the log messages read from each input
+	InputMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "input_messages_total",
+		Help:      "Log messages read from each input.",
+	}, []string{"input"})
+
+	// EventsKept counts the log messages that passed the filter
+	EventsKept = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_kept_total",
+		Help:      "Log messages kept by the filter.",
+	})
+
+	// EventsDropped counts the log messages dropped by the filter, by reason
+	EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Log messages dropped by the filter, by reason.",
+	}, []string{"reason"})
+
+	// EventsExtracted counts the log messages whose fields were extracted, by message format
+	EventsExtracted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_extracted_total",
+		Help:      "Log messages whose fields were extracted, by message format.",
+	}, []string{"format"})
+
+	// Redactions counts the secrets and personal data redacted from the log messages, by detector
+	Redactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redactions_total",
+		Help:      "Secrets and personal data redacted from the log messages, by detector.",
+	}, []string{"detector"})
+
+	// BatchesBuilt counts the batches handed over to the dispatch
+	BatchesBuilt = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batches_built_total",
+		Help:      "Batches handed over to the dispatch.",
+	})
+
+	// BatchEvents observes the number of events per batch
+	BatchEvents = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_events",
+		Help:      "Events per batch.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	// BatchBytes observes the size of the batches
+	BatchBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_bytes",
+		Help:      "Size of the batches in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+	})
+
+	// PutDuration observes the latency of the deliveries to the sinks, successful or not
+	PutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sink_put_duration_seconds",
+		Help:      "Latency of the batch deliveries to the sinks.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink"})
+
+	// PutErrors counts the failed delivery attempts to the sinks
+	PutErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_put_errors_total",
+		Help:      "Failed batch delivery attempts to the sinks.",
+	}, []string{"sink"})
+
+	// Deliveries counts the batches done with by the sinks, by outcome
+	Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_batches_total",
+		Help:      "Batches done with by the sinks, by outcome: delivered, dead_lettered, failed, dropped_oldest, dropped_newest or spilled.",
+	}, []string{"sink", "outcome"})
+
+	// QueueDepth is the number of batches waiting in the queue of each sink
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sink_queue_depth",
+		Help:      "Batches waiting in the queue of the sinks.",
+	}, []string{"sink"})
+
+	// EventsDroppedByLevel counts the events dropped by level because a queue was full
+	EventsDroppedByLevel = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backpressure_events_dropped_total",
+		Help:      "Events dropped by level because a sink queue was full.",
+	})
+
+	// HTTPRequests counts the requests of the HTTP input, by status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_input_requests_total",
+		Help:      "Requests of the HTTP input, by status code.",
+	}, []string{"code"})
+
+	// CheckpointBlocked is the number of acknowledged batches waiting for an earlier batch before the cursor can move past them
+	CheckpointBlocked = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "checkpoint_blocked_batches",
+		Help:      "Acknowledged batches waiting for an earlier batch to be acknowledged before the cursor moves past them.",
+	})
+
+	// CheckpointSkipped counts the batches the cursor moved past without them being acknowledged, whose events may be lost
+	CheckpointSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "checkpoint_skipped_batches_total",
+		Help:      "Batches the cursor moved past without them being acknowledged, too many later batches being acknowledged.",
+	})
+)
+
+// lastInput is the unix nano time of the last log message read
+var lastInput int64
+
+// RecordInput records that a log message was read from the input, whether or not it is kept
+func RecordInput() {
+	atomic.StoreInt64(&lastInput, time.Now().UnixNano())
+}
+
+// LastInput is when the last log message was read, the zero time before the first one
+func LastInput() time.Time {
+	last := atomic.LoadInt64(&lastInput)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+func init() {
+	prometheus.MustRegister(
+		LinesRead,
+		InputMessages,
+		EventsKept,
+		EventsDropped,
+		EventsExtracted,
+		Redactions,
+		BatchesBuilt,
+		BatchEvents,
+		BatchBytes,
+		PutDuration,
+		PutErrors,
+		Deliveries,
+		QueueDepth,
+		EventsDroppedByLevel,
+		HTTPRequests,
+		CheckpointBlocked,
+		CheckpointSkipped,
+	)
+}